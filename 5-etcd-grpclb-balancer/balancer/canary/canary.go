@@ -0,0 +1,147 @@
+package canary
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"etcd-example/5-etcd-grpclb-balancer/balancer/weight"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// Name is the name of canary balancer.
+const Name = "canary"
+
+// LabelCanary is the label of instances which receive the canary traffic.
+// Instances with any other label are treated as stable.
+const LabelCanary = "canary"
+
+var (
+	minWeight = 1
+	maxWeight = 5
+)
+
+// splitKey is the type used as the key to store Split in the Attributes
+// field of resolver.Address.
+type splitKey struct{}
+
+// Split holds the percentage of RPCs sent to canary instances. The same Split
+// is shared by all addresses of a target, so the resolver can change it at
+// any time without rebuilding the picker.
+type Split struct {
+	percent int32
+}
+
+// NewSplit creates a Split which sends no traffic to canary instances.
+func NewSplit() *Split {
+	return &Split{}
+}
+
+// Percent returns the percentage of RPCs sent to canary instances.
+func (s *Split) Percent() int {
+	return int(atomic.LoadInt32(&s.percent))
+}
+
+// SetPercent updates the percentage of RPCs sent to canary instances. The
+// value is clamped to [0, 100].
+func (s *Split) SetPercent(percent int) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	atomic.StoreInt32(&s.percent, int32(percent))
+}
+
+// SetSplit returns a copy of addr in which the Attributes field is updated
+// with split. Other attributes of addr are kept.
+func SetSplit(addr resolver.Address, split *Split) resolver.Address {
+	if addr.Attributes == nil {
+		addr.Attributes = attributes.New()
+	}
+	addr.Attributes = addr.Attributes.WithValues(splitKey{}, split)
+	return addr
+}
+
+// GetSplit returns the Split stored in the Attributes fields of addr.
+func GetSplit(addr resolver.Address) *Split {
+	if addr.Attributes == nil {
+		return nil
+	}
+	v := addr.Attributes.Value(splitKey{})
+	s, _ := v.(*Split)
+	return s
+}
+
+// newBuilder creates a new canary balancer builder.
+func newBuilder() balancer.Builder {
+	return base.NewBalancerBuilderV2(Name, &canaryPickerBuilder{}, base.Config{HealthCheck: false})
+}
+
+func init() {
+	balancer.Register(newBuilder())
+}
+
+type canaryPickerBuilder struct{}
+
+func (*canaryPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	grpclog.Infof("canaryPicker: newPicker called with info: %v", info)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+	p := &canaryPicker{}
+	for subConn, addr := range info.ReadySCs {
+		if p.split == nil {
+			p.split = GetSplit(addr.Address)
+		}
+		node := weight.GetAddrInfo(addr.Address)
+		if node.Weight <= 0 {
+			node.Weight = minWeight
+		} else if node.Weight > maxWeight {
+			node.Weight = maxWeight
+		}
+		for i := 0; i < node.Weight; i++ {
+			if node.Label == LabelCanary {
+				p.canary = append(p.canary, subConn)
+			} else {
+				p.stable = append(p.stable, subConn)
+			}
+		}
+	}
+	return p
+}
+
+type canaryPicker struct {
+	// canary and stable are the weighted snapshots of the canary and stable
+	// instances when this picker was created. The slices are immutable.
+	canary []balancer.SubConn
+	stable []balancer.SubConn
+	// split is read on every Pick so percentage changes apply immediately.
+	split *Split
+
+	mu sync.Mutex
+}
+
+func (p *canaryPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	scs := p.stable
+	// 没有稳定版实例时，所有请求都发往灰度实例
+	if len(p.canary) > 0 && (len(scs) == 0 || rand.Intn(100) < p.percent()) {
+		scs = p.canary
+	}
+	sc := scs[rand.Intn(len(scs))]
+	return balancer.PickResult{SubConn: sc}, nil
+}
+
+func (p *canaryPicker) percent() int {
+	if p.split == nil {
+		return 0
+	}
+	return p.split.Percent()
+}
@@ -0,0 +1,134 @@
+package canary
+
+import (
+	"testing"
+
+	"etcd-example/5-etcd-grpclb-balancer/balancer/weight"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	name string
+}
+
+func (*testSubConn) UpdateAddresses([]resolver.Address) {}
+func (*testSubConn) Connect()                           {}
+
+//buildPicker 以相同的split为每个实例创建SubConn并构建picker
+func buildPicker(t *testing.T, split *Split, nodes map[string]weight.AddrInfo) *canaryPicker {
+	t.Helper()
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for name, node := range nodes {
+		addr := weight.SetAddrInfo(resolver.Address{Addr: name}, node)
+		info.ReadySCs[&testSubConn{name: name}] = base.SubConnInfo{Address: SetSplit(addr, split)}
+	}
+	p, ok := (&canaryPickerBuilder{}).Build(info).(*canaryPicker)
+	if !ok {
+		t.Fatal("Build did not return a canaryPicker")
+	}
+	return p
+}
+
+//pickCanary 返回n次Pick中发往灰度实例的次数
+func pickCanary(t *testing.T, p *canaryPicker, n int) int {
+	t.Helper()
+	canary := 0
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.SubConn.(*testSubConn).name == "canary" {
+			canary++
+		}
+	}
+	return canary
+}
+
+func TestPickerSplit(t *testing.T) {
+	nodes := map[string]weight.AddrInfo{
+		"stable": {Weight: 1},
+		"canary": {Weight: 1, Label: LabelCanary},
+	}
+	const n = 10000
+	tests := []struct {
+		percent  int
+		min, max int
+	}{
+		{percent: 0, min: 0, max: 0},
+		{percent: 100, min: n, max: n},
+		{percent: 30, min: n * 25 / 100, max: n * 35 / 100},
+		{percent: -10, min: 0, max: 0},
+		{percent: 200, min: n, max: n},
+	}
+	for _, tt := range tests {
+		split := NewSplit()
+		split.SetPercent(tt.percent)
+		got := pickCanary(t, buildPicker(t, split, nodes), n)
+		if got < tt.min || got > tt.max {
+			t.Errorf("percent %d: %d of %d picks went to canary, want [%d, %d]", tt.percent, got, n, tt.min, tt.max)
+		}
+	}
+}
+
+func TestPickerSplitChange(t *testing.T) {
+	split := NewSplit()
+	p := buildPicker(t, split, map[string]weight.AddrInfo{
+		"stable": {Weight: 1},
+		"canary": {Weight: 1, Label: LabelCanary},
+	})
+	if got := pickCanary(t, p, 100); got != 0 {
+		t.Fatalf("%d picks went to canary before the split changed, want 0", got)
+	}
+	//修改比例不需要重建picker
+	split.SetPercent(100)
+	if got := pickCanary(t, p, 100); got != 100 {
+		t.Fatalf("%d picks went to canary after the split changed, want 100", got)
+	}
+}
+
+func TestPickerFallback(t *testing.T) {
+	//只有灰度实例时，比例为0也发往灰度实例
+	p := buildPicker(t, NewSplit(), map[string]weight.AddrInfo{"canary": {Weight: 1, Label: LabelCanary}})
+	if got := pickCanary(t, p, 100); got != 100 {
+		t.Errorf("%d picks went to canary with no stable instance, want 100", got)
+	}
+	//只有稳定版实例时，比例为100也发往稳定版实例
+	split := NewSplit()
+	split.SetPercent(100)
+	p = buildPicker(t, split, map[string]weight.AddrInfo{"stable": {Weight: 1}})
+	if got := pickCanary(t, p, 100); got != 0 {
+		t.Errorf("%d picks went to canary with no canary instance, want 0", got)
+	}
+}
+
+func TestPickerWeight(t *testing.T) {
+	tests := []struct {
+		weight int
+		want   int
+	}{
+		{weight: -1, want: minWeight},
+		{weight: 0, want: minWeight},
+		{weight: 3, want: 3},
+		{weight: 10, want: maxWeight},
+	}
+	for _, tt := range tests {
+		p := buildPicker(t, NewSplit(), map[string]weight.AddrInfo{
+			"stable": {Weight: tt.weight},
+			"canary": {Weight: tt.weight, Label: LabelCanary},
+		})
+		if len(p.stable) != tt.want || len(p.canary) != tt.want {
+			t.Errorf("weight %d: got %d stable and %d canary entries, want %d", tt.weight, len(p.stable), len(p.canary), tt.want)
+		}
+	}
+}
+
+func TestPickerNoSubConn(t *testing.T) {
+	p := (&canaryPickerBuilder{}).Build(base.PickerBuildInfo{})
+	if _, err := p.Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Errorf("Pick() error = %v, want %v", err, balancer.ErrNoSubConnAvailable)
+	}
+}
@@ -25,7 +25,9 @@ type attributeKey struct{}
 
 // AddrInfo will be stored inside Address metadata in order to use weighted balancer.
 type AddrInfo struct {
-	Weight int
+	Weight  int
+	Version string // version of the instance, e.g. v1.2.0
+	Label   string // release label of the instance, e.g. canary or stable
 }
 
 // SetAddrInfo returns a copy of addr in which the Attributes field is updated
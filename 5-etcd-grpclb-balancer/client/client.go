@@ -18,17 +18,20 @@ var (
 	// EtcdEndpoints etcd地址
 	EtcdEndpoints = []string{"localhost:2379"}
 	// SerName 服务名称
-	SerName    = "simple_grpc"
-	grpcClient pb.SimpleClient
+	SerName = "simple_grpc"
+	// DefaultServiceConfig 默认服务配置，etcd中存在/grpclb/simple_grpc/__config时以etcd中的配置为准
+	DefaultServiceConfig = `{"loadBalancingPolicy":"weight"}`
+	grpcClient           pb.SimpleClient
 )
 
 func main() {
-	r := etcdv3.NewServiceDiscovery(EtcdEndpoints)
+	//服务地址数低于上次正常数量的一半时触发保护
+	r := etcdv3.NewServiceDiscovery(EtcdEndpoints, etcdv3.WithPanicThreshold(0.5))
 	resolver.Register(r)
 	// 连接服务器
 	conn, err := grpc.Dial(
 		fmt.Sprintf("%s:///%s", r.Scheme(), SerName),
-		grpc.WithBalancerName("weight"),
+		grpc.WithDefaultServiceConfig(DefaultServiceConfig),
 		grpc.WithInsecure(),
 	)
 	if err != nil {
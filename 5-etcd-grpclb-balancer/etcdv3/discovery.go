@@ -2,31 +2,106 @@ package etcdv3
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"etcd-example/5-etcd-grpclb-balancer/balancer/canary"
 	"etcd-example/5-etcd-grpclb-balancer/balancer/weight"
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"go.etcd.io/etcd/clientv3"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
 )
 
 const schema = "grpclb"
 
+//configPrefix 以该前缀开头的key为服务配置，不是服务地址
+const configPrefix = "__"
+
+//canaryKey 灰度流量百分比的配置key，如/grpclb/simple_grpc/__canary
+const canaryKey = configPrefix + "canary"
+
+//serviceConfigKey gRPC服务配置(JSON)的配置key，如/grpclb/simple_grpc/__config
+const serviceConfigKey = configPrefix + "config"
+
+//resolveNowInterval 两次ResolveNow重新拉取服务列表的最小间隔
+const resolveNowInterval = 5 * time.Second
+
+//defaultThrottle 默认的推送节流间隔
+const defaultThrottle = 100 * time.Millisecond
+
+//defaultPanicTimeout 默认的保护持续时间，超过后认为是真实的缩容
+const defaultPanicTimeout = 5 * time.Minute
+
+//rewatchInterval watch中断后重新拉取失败时的重试间隔
+const rewatchInterval = time.Second
+
 //ServiceDiscovery 服务发现
 type ServiceDiscovery struct {
 	cli        *clientv3.Client //etcd client
+	ctx        context.Context  //Close时取消，停止watcher
+	cancel     context.CancelFunc
 	cc         resolver.ClientConn
-	serverList sync.Map //服务列表
-	prefix     string   //监视的前缀
+	serverList sync.Map      //服务列表
+	prefix     string        //监视的前缀
+	split      *canary.Split //灰度流量比例
+	//从etcd获取的gRPC服务配置，为nil时使用客户端的默认配置
+	serviceConfig *serviceconfig.ParseResult
+	resolveNow    chan struct{} //ResolveNow通知watcher重新拉取服务列表
+	lastResolve   time.Time     //上一次重新拉取的时间
+	throttle      time.Duration //推送节流间隔
+	dirty         bool          //服务列表或配置有未推送的变更
+	//保护阈值，服务地址数低于上次正常推送数量的该比例时拒绝推送，为0时不保护
+	panicThreshold float64
+	lastGood       []resolver.Address //上次正常推送的服务地址
+	panicTimeout   time.Duration      //保护持续超过该时间后接受当前的服务地址，为0时一直保护
+	panicSince     time.Time          //开始触发保护的时间，未触发时为零值
+	//触发保护时的回调，参数为上次正常推送的地址数和当前地址数
+	onPanic func(lastGood, current int)
+}
+
+//Option 服务发现的可选配置
+type Option func(*ServiceDiscovery)
+
+//WithThrottle 设置推送节流间隔，第一个变更到达后等待该间隔，期间的所有变更合并为一次推送，
+//持续变更时每个间隔最多推送一次。为0时每个watch响应推送一次
+func WithThrottle(d time.Duration) Option {
+	return func(s *ServiceDiscovery) {
+		s.throttle = d
+	}
+}
+
+//WithPanicThreshold 开启保护模式，服务地址数低于上次正常推送数量的threshold比例时，
+//继续使用上次正常的服务地址，防止误删前缀导致所有客户端不可用。
+//服务地址数恢复或保护超过WithPanicTimeout设置的时间后解除保护
+func WithPanicThreshold(threshold float64) Option {
+	return func(s *ServiceDiscovery) {
+		s.panicThreshold = threshold
+	}
+}
+
+//WithPanicTimeout 设置保护的持续时间，服务地址数持续低于阈值超过d时认为是真实的缩容，
+//接受当前的服务地址并以此作为新的正常数量。默认为5分钟，为0时一直保护直到服务地址数恢复
+func WithPanicTimeout(d time.Duration) Option {
+	return func(s *ServiceDiscovery) {
+		s.panicTimeout = d
+	}
+}
+
+//WithPanicHandler 设置触发保护时的回调，用于上报异常
+func WithPanicHandler(fn func(lastGood, current int)) Option {
+	return func(s *ServiceDiscovery) {
+		s.onPanic = fn
+	}
 }
 
 //NewServiceDiscovery  新建发现服务
-func NewServiceDiscovery(endpoints []string) resolver.Builder {
+func NewServiceDiscovery(endpoints []string, opts ...Option) resolver.Builder {
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: 5 * time.Second,
@@ -35,9 +110,20 @@ func NewServiceDiscovery(endpoints []string) resolver.Builder {
 		log.Fatal(err)
 	}
 
-	return &ServiceDiscovery{
-		cli: cli,
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ServiceDiscovery{
+		cli:          cli,
+		ctx:          ctx,
+		cancel:       cancel,
+		split:        canary.NewSplit(),
+		resolveNow:   make(chan struct{}, 1),
+		throttle:     defaultThrottle,
+		panicTimeout: defaultPanicTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 //Build 为给定目标创建一个新的`resolver`，当调用`grpc.Dial()`时执行
@@ -52,17 +138,26 @@ func (s *ServiceDiscovery) Build(target resolver.Target, cc resolver.ClientConn,
 	}
 
 	for _, ev := range resp.Kvs {
-		s.SetServiceList(string(ev.Key), string(ev.Value))
+		s.setServiceList(string(ev.Key), string(ev.Value))
 	}
-	s.cc.UpdateState(resolver.State{Addresses: s.getServices()})
-	//监视前缀，修改变更的server
-	go s.watcher()
+	//现有的key合并为一次推送，没有key时也推送空列表
+	s.dirty = false
+	s.updateState()
+	s.lastResolve = time.Now()
+	//从Get的下一个版本开始监视前缀，避免遗漏两者之间的变更
+	go s.watcher(resp.Header.Revision + 1)
 	return s, nil
 }
 
 // ResolveNow 监视目标更新
+//gRPC在连接失败后调用，通知watcher重新拉取服务列表，修正遗漏watch事件导致的偏差
 func (s *ServiceDiscovery) ResolveNow(rn resolver.ResolveNowOption) {
 	log.Println("ResolveNow")
+	select {
+	case s.resolveNow <- struct{}{}:
+	default:
+		//已有未处理的通知
+	}
 }
 
 //Scheme return schema
@@ -73,49 +168,218 @@ func (s *ServiceDiscovery) Scheme() string {
 //Close 关闭
 func (s *ServiceDiscovery) Close() {
 	log.Println("Close")
+	s.cancel()
 	s.cli.Close()
 }
 
 //watcher 监听前缀
-func (s *ServiceDiscovery) watcher() {
-	rch := s.cli.Watch(context.Background(), s.prefix, clientv3.WithPrefix())
-	log.Printf("watching prefix:%s now...", s.prefix)
-	for wresp := range rch {
-		for _, ev := range wresp.Events {
-			switch ev.Type {
-			case mvccpb.PUT: //新增或修改
-				s.SetServiceList(string(ev.Kv.Key), string(ev.Kv.Value))
-			case mvccpb.DELETE: //删除
-				s.DelServiceList(string(ev.Kv.Key))
+//服务列表只在watcher协程中修改，ResolveNow触发的重新拉取也在这里执行。
+//watch出错(如起始版本已被压缩)或关闭时，重新拉取服务列表并从新的版本重新watch
+func (s *ServiceDiscovery) watcher(rev int64) {
+	var (
+		rch                                   clientv3.WatchChan
+		cancel                                context.CancelFunc
+		relistTimer, flushTimer, rewatchTimer <-chan time.Time
+		panicTimer                            <-chan time.Time
+	)
+	watch := func(rev int64) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(s.ctx)
+		rch = s.cli.Watch(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev))
+		log.Printf("watching prefix:%s from revision %d now...", s.prefix, rev)
+	}
+	watch(rev)
+	defer func() { cancel() }()
+	//有变更时，在节流间隔结束后统一推送
+	schedule := func() {
+		if !s.dirty || flushTimer != nil {
+			return
+		}
+		if s.throttle <= 0 {
+			s.flush()
+			return
+		}
+		flushTimer = time.After(s.throttle)
+	}
+	//重新拉取服务列表后从新的版本重新watch，拉取失败时稍后重试
+	rewatch := func() {
+		rev, err := s.relist()
+		if err != nil {
+			log.Println("relist err:", err)
+			rewatchTimer = time.After(rewatchInterval)
+			return
+		}
+		watch(rev + 1)
+		schedule()
+	}
+	for {
+		//保护超时后重新推送，接受当前的服务地址
+		if !s.panicSince.IsZero() && s.panicTimeout > 0 && panicTimer == nil {
+			panicTimer = time.After(s.panicTimeout - time.Since(s.panicSince))
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case wresp, ok := <-rch:
+			if !ok || wresp.Err() != nil {
+				log.Printf("watch prefix:%s closed, err: %v", s.prefix, wresp.Err())
+				//停止旧的watch，rch为nil时不再接收
+				cancel()
+				rch = nil
+				rewatch()
+				continue
+			}
+			for _, ev := range wresp.Events {
+				switch ev.Type {
+				case mvccpb.PUT: //新增或修改
+					s.setServiceList(string(ev.Kv.Key), string(ev.Kv.Value))
+				case mvccpb.DELETE: //删除
+					s.delServiceList(string(ev.Kv.Key))
+				}
+			}
+			schedule()
+		case <-flushTimer:
+			flushTimer = nil
+			s.flush()
+		case <-s.resolveNow:
+			if relistTimer != nil {
+				//已经安排了重新拉取
+				continue
+			}
+			//限制重新拉取的频率
+			relistTimer = time.After(resolveNowInterval - time.Since(s.lastResolve))
+		case <-relistTimer:
+			relistTimer = nil
+			if _, err := s.relist(); err != nil {
+				log.Println("relist err:", err)
+			}
+			schedule()
+		case <-rewatchTimer:
+			rewatchTimer = nil
+			rewatch()
+		case <-panicTimer:
+			panicTimer = nil
+			if !s.panicSince.IsZero() {
+				s.updateState()
 			}
 		}
 	}
 }
 
-//SetServiceList 设置服务地址
-func (s *ServiceDiscovery) SetServiceList(key, val string) {
-	//获取服务地址
-	addr := resolver.Address{Addr: strings.TrimPrefix(key, s.prefix)}
-	//获取服务地址权重
-	nodeWeight, err := strconv.Atoi(val)
+//relist 重新拉取前缀下所有key，与本地服务列表对比修正，返回拉取时的版本号
+func (s *ServiceDiscovery) relist() (int64, error) {
+	s.lastResolve = time.Now()
+	resp, err := s.cli.Get(s.ctx, s.prefix, clientv3.WithPrefix())
 	if err != nil {
-		//非数字字符默认权重为1
-		nodeWeight = 1
+		return 0, err
+	}
+	keys := make(map[string]bool, len(resp.Kvs))
+	for _, ev := range resp.Kvs {
+		keys[string(ev.Key)] = true
+		//未变化的服务地址不重新设置，避免重建子连接
+		if v, ok := s.serverList.Load(string(ev.Key)); ok && sameNodeInfo(v.(resolver.Address), string(ev.Value)) {
+			continue
+		}
+		s.setServiceList(string(ev.Key), string(ev.Value))
 	}
-	//把服务地址权重存储到resolver.Address的元数据中
-	addr = weight.SetAddrInfo(addr, weight.AddrInfo{Weight: nodeWeight})
+	//删除etcd中已不存在的服务地址和配置
+	s.serverList.Range(func(k, v interface{}) bool {
+		if !keys[k.(string)] {
+			s.delServiceList(k.(string))
+		}
+		return true
+	})
+	if !keys[s.prefix+canaryKey] && s.split.Percent() != 0 {
+		s.delConfig(s.prefix + canaryKey)
+	}
+	if !keys[s.prefix+serviceConfigKey] && s.serviceConfig != nil {
+		s.delConfig(s.prefix + serviceConfigKey)
+	}
+	log.Printf("relist prefix:%s, %d keys", s.prefix, len(resp.Kvs))
+	return resp.Header.Revision, nil
+}
+
+//setServiceList 设置服务地址，只修改本地服务列表，由watcher协程统一推送给gRPC
+func (s *ServiceDiscovery) setServiceList(key, val string) {
+	if s.isConfigKey(key) {
+		s.setConfig(key, val)
+		return
+	}
+	//获取服务地址
+	addr := resolver.Address{Addr: strings.TrimPrefix(key, s.prefix)}
+	//获取服务地址权重、版本和标签
+	info := parseNodeInfo(val)
+	//把服务地址信息存储到resolver.Address的元数据中
+	addr = weight.SetAddrInfo(addr, weight.AddrInfo{Weight: info.Weight, Version: info.Version, Label: info.Label})
+	//所有地址共享同一个灰度比例，修改比例不需要重建picker
+	addr = canary.SetSplit(addr, s.split)
 	s.serverList.Store(key, addr)
-	s.cc.UpdateState(resolver.State{Addresses: s.getServices()})
+	s.dirty = true
 	log.Println("put key :", key, "wieght:", val)
 }
 
-//DelServiceList 删除服务地址
-func (s *ServiceDiscovery) DelServiceList(key string) {
+//delServiceList 删除服务地址，只修改本地服务列表，由watcher协程统一推送给gRPC
+func (s *ServiceDiscovery) delServiceList(key string) {
+	if s.isConfigKey(key) {
+		s.delConfig(key)
+		return
+	}
 	s.serverList.Delete(key)
-	s.cc.UpdateState(resolver.State{Addresses: s.getServices()})
+	s.dirty = true
 	log.Println("del key:", key)
 }
 
+//flush 推送未推送的变更
+func (s *ServiceDiscovery) flush() {
+	if !s.dirty {
+		return
+	}
+	s.dirty = false
+	s.updateState()
+}
+
+//updateState 把服务地址和服务配置推送给gRPC
+func (s *ServiceDiscovery) updateState() {
+	addrs := s.getServices()
+	if s.inPanic(len(addrs), time.Now()) {
+		//触发保护，继续推送上次正常的服务地址，服务配置照常更新
+		log.Printf("panic threshold reached, prefix:%s last good:%d current:%d", s.prefix, len(s.lastGood), len(addrs))
+		if s.onPanic != nil {
+			s.onPanic(len(s.lastGood), len(addrs))
+		}
+		addrs = s.lastGood
+	} else {
+		s.lastGood = addrs
+	}
+	s.cc.UpdateState(resolver.State{Addresses: addrs, ServiceConfig: s.serviceConfig})
+}
+
+//inPanic 判断是否触发保护，服务地址数低于阈值持续超过panicTimeout时不再保护，
+//当前的服务地址成为新的正常数量
+func (s *ServiceDiscovery) inPanic(n int, now time.Time) bool {
+	if !s.belowPanicThreshold(n) {
+		s.panicSince = time.Time{}
+		return false
+	}
+	if s.panicSince.IsZero() {
+		s.panicSince = now
+	}
+	if s.panicTimeout > 0 && now.Sub(s.panicSince) >= s.panicTimeout {
+		log.Printf("panic timeout, prefix:%s accept %d addresses", s.prefix, n)
+		s.panicSince = time.Time{}
+		return false
+	}
+	return true
+}
+
+//belowPanicThreshold 判断服务地址数是否低于保护阈值
+func (s *ServiceDiscovery) belowPanicThreshold(n int) bool {
+	if s.panicThreshold <= 0 || len(s.lastGood) == 0 {
+		return false
+	}
+	return float64(n) < s.panicThreshold*float64(len(s.lastGood))
+}
+
 //GetServices 获取服务地址
 func (s *ServiceDiscovery) getServices() []resolver.Address {
 	addrs := make([]resolver.Address, 0, 10)
@@ -125,3 +389,67 @@ func (s *ServiceDiscovery) getServices() []resolver.Address {
 	})
 	return addrs
 }
+
+//isConfigKey 判断key是否为服务配置
+func (s *ServiceDiscovery) isConfigKey(key string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(key, s.prefix), configPrefix)
+}
+
+//setConfig 设置服务配置
+func (s *ServiceDiscovery) setConfig(key, val string) {
+	switch strings.TrimPrefix(key, s.prefix) {
+	case canaryKey:
+		percent, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			log.Printf("invalid canary percent %q: %v", val, err)
+			return
+		}
+		s.split.SetPercent(percent)
+		log.Println("canary percent:", s.split.Percent())
+	case serviceConfigKey:
+		sc := s.cc.ParseServiceConfig(val)
+		if sc.Err != nil {
+			//配置不合法时保留上一次的配置
+			log.Printf("invalid service config %q: %v", val, sc.Err)
+			return
+		}
+		s.serviceConfig = sc
+		s.dirty = true
+		log.Println("service config:", val)
+	default:
+		log.Println("unknown config key:", key)
+	}
+}
+
+//delConfig 删除服务配置，恢复默认值
+func (s *ServiceDiscovery) delConfig(key string) {
+	switch strings.TrimPrefix(key, s.prefix) {
+	case canaryKey:
+		s.split.SetPercent(0)
+		log.Println("canary percent:", s.split.Percent())
+	case serviceConfigKey:
+		s.serviceConfig = nil
+		s.dirty = true
+		log.Println("service config deleted")
+	}
+}
+
+//sameNodeInfo 判断服务地址信息与value是否一致
+func sameNodeInfo(addr resolver.Address, val string) bool {
+	info := parseNodeInfo(val)
+	return weight.GetAddrInfo(addr) == weight.AddrInfo{Weight: info.Weight, Version: info.Version, Label: info.Label}
+}
+
+//parseNodeInfo 解析服务地址的value，兼容纯数字的权重和JSON格式的NodeInfo
+func parseNodeInfo(val string) NodeInfo {
+	info := NodeInfo{Weight: 1}
+	if w, err := strconv.Atoi(val); err == nil {
+		info.Weight = w
+		return info
+	}
+	//非数字且非JSON字符默认权重为1
+	if err := json.Unmarshal([]byte(val), &info); err != nil {
+		return NodeInfo{Weight: 1}
+	}
+	return info
+}
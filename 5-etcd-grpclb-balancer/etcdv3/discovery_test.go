@@ -0,0 +1,344 @@
+package etcdv3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//fakeClientConn 记录推送给gRPC的状态
+type fakeClientConn struct {
+	mu     sync.Mutex
+	states []resolver.State
+}
+
+//rawServiceConfig 未解析的服务配置，用于比较推送的配置
+type rawServiceConfig struct {
+	serviceconfig.Config
+	json string
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.states = append(cc.states, state)
+}
+
+func (cc *fakeClientConn) ReportError(error) {}
+
+func (cc *fakeClientConn) NewAddress([]resolver.Address) {}
+
+func (cc *fakeClientConn) NewServiceConfig(string) {}
+
+func (cc *fakeClientConn) ParseServiceConfig(js string) *serviceconfig.ParseResult {
+	if !json.Valid([]byte(js)) {
+		return &serviceconfig.ParseResult{Err: errors.New("invalid service config")}
+	}
+	return &serviceconfig.ParseResult{Config: rawServiceConfig{json: js}}
+}
+
+//count 返回推送的次数
+func (cc *fakeClientConn) count() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return len(cc.states)
+}
+
+//wait 等待第n次推送并返回该次推送的状态，5秒内没有推送时测试失败
+func (cc *fakeClientConn) wait(t *testing.T, n int) resolver.State {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for update %d, got %d", n, cc.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.states[n-1]
+}
+
+//newTestDiscovery 新建监视测试独占前缀的服务发现，返回写入服务地址的etcd client
+func newTestDiscovery(t *testing.T, opts ...Option) (*ServiceDiscovery, *fakeClientConn, *clientv3.Client) {
+	cli := etcdtest.NewClient(t)
+	s := NewServiceDiscovery(cli.Endpoints(), opts...).(*ServiceDiscovery)
+	cc := &fakeClientConn{}
+	s.cc = cc
+	s.prefix = "/" + schema + etcdtest.Prefix(t) + "/"
+	return s, cc, cli
+}
+
+//build 启动服务发现，监视newTestDiscovery设置的前缀
+func build(t *testing.T, s *ServiceDiscovery, cc *fakeClientConn) {
+	t.Helper()
+	endpoint := strings.TrimSuffix(strings.TrimPrefix(s.prefix, "/"+schema+"/"), "/")
+	if _, err := s.Build(resolver.Target{Scheme: schema, Endpoint: endpoint}, cc, resolver.BuildOption{}); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+}
+
+//put 写入服务地址或配置，key为去掉前缀的部分
+func put(t *testing.T, cli *clientv3.Client, s *ServiceDiscovery, key, val string) {
+	t.Helper()
+	if _, err := cli.Put(context.Background(), s.prefix+key, val); err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+//del 删除服务地址或配置，key为去掉前缀的部分
+func del(t *testing.T, cli *clientv3.Client, s *ServiceDiscovery, key string) {
+	t.Helper()
+	if _, err := cli.Delete(context.Background(), s.prefix+key); err != nil {
+		t.Fatalf("Delete %s: %v", key, err)
+	}
+}
+
+//addrs 返回排序后的服务地址
+func addrs(state resolver.State) string {
+	var list []string
+	for _, a := range state.Addresses {
+		list = append(list, a.Addr)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+//logBuffer 记录日志，用于统计重新拉取的次数
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+//count 返回包含s的日志行数
+func (b *logBuffer) count(s string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Count(b.buf.String(), s)
+}
+
+//serviceConfig 返回推送的服务配置，没有配置时返回空字符串
+func serviceConfig(state resolver.State) string {
+	if state.ServiceConfig == nil {
+		return ""
+	}
+	return state.ServiceConfig.Config.(rawServiceConfig).json
+}
+
+func TestParseNodeInfo(t *testing.T) {
+	tests := []struct {
+		val  string
+		want NodeInfo
+	}{
+		//兼容纯数字的权重
+		{val: "3", want: NodeInfo{Weight: 3}},
+		{val: "0", want: NodeInfo{Weight: 0}},
+		{val: `{"weight":2,"version":"v1.2.0","label":"canary"}`, want: NodeInfo{Weight: 2, Version: "v1.2.0", Label: "canary"}},
+		//JSON中没有权重时默认为1
+		{val: `{"version":"v1.2.0"}`, want: NodeInfo{Weight: 1, Version: "v1.2.0"}},
+		//无法解析时默认权重为1，不保留部分解析的字段
+		{val: "", want: NodeInfo{Weight: 1}},
+		{val: "heavy", want: NodeInfo{Weight: 1}},
+		{val: `{"weight":"2","label":"canary"}`, want: NodeInfo{Weight: 1}},
+	}
+	for _, tt := range tests {
+		if got := parseNodeInfo(tt.val); got != tt.want {
+			t.Errorf("parseNodeInfo(%q) = %+v, want %+v", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestInPanic(t *testing.T) {
+	addrs := func(n int) []resolver.Address {
+		return make([]resolver.Address, n)
+	}
+	start := time.Unix(1600000000, 0)
+	tests := []struct {
+		name     string
+		lastGood int
+		since    time.Duration //开始保护的时间距start的偏移，小于0时未触发保护
+		n        int
+		now      time.Duration
+		want     bool
+	}{
+		{name: "没有正常记录", lastGood: 0, since: -1, n: 0, want: false},
+		{name: "高于阈值", lastGood: 10, since: -1, n: 5, want: false},
+		{name: "低于阈值", lastGood: 10, since: -1, n: 4, want: true},
+		{name: "保护中", lastGood: 10, since: 0, n: 0, now: time.Minute, want: true},
+		{name: "恢复", lastGood: 10, since: 0, n: 8, now: time.Minute, want: false},
+		{name: "保护超时", lastGood: 10, since: 0, n: 2, now: 5 * time.Minute, want: false},
+	}
+	for _, tt := range tests {
+		s := &ServiceDiscovery{
+			panicThreshold: 0.5,
+			panicTimeout:   5 * time.Minute,
+			lastGood:       addrs(tt.lastGood),
+		}
+		if tt.since >= 0 {
+			s.panicSince = start.Add(tt.since)
+		}
+		if got := s.inPanic(tt.n, start.Add(tt.now)); got != tt.want {
+			t.Errorf("%s: inPanic(%d) = %v, want %v", tt.name, tt.n, got, tt.want)
+		}
+		//未触发保护时清除开始时间
+		if !tt.want && !s.panicSince.IsZero() {
+			t.Errorf("%s: panicSince = %v, want zero", tt.name, s.panicSince)
+		}
+	}
+}
+
+func TestInPanicDisabled(t *testing.T) {
+	//阈值为0时不保护
+	s := &ServiceDiscovery{lastGood: make([]resolver.Address, 10)}
+	if s.inPanic(0, time.Now()) {
+		t.Error("inPanic with zero threshold = true, want false")
+	}
+	//超时为0时一直保护
+	s = &ServiceDiscovery{panicThreshold: 0.5, lastGood: make([]resolver.Address, 10)}
+	now := time.Now()
+	for _, d := range []time.Duration{0, time.Hour, 24 * time.Hour} {
+		if !s.inPanic(0, now.Add(d)) {
+			t.Errorf("inPanic after %v = false, want true", d)
+		}
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	s, cc, cli := newTestDiscovery(t)
+	defer cli.Close()
+	defer s.Close()
+	const valid = `{"loadBalancingPolicy":"weight"}`
+	put(t, cli, s, "127.0.0.1:8000", "1")
+	put(t, cli, s, serviceConfigKey, valid)
+	build(t, s, cc)
+
+	//合法的配置随服务地址一起推送
+	state := cc.wait(t, 1)
+	if got := serviceConfig(state); got != valid {
+		t.Fatalf("service config = %q, want %q", got, valid)
+	}
+	//不合法的配置保留上一次的配置
+	put(t, cli, s, serviceConfigKey, "{")
+	put(t, cli, s, "127.0.0.1:8001", "1")
+	state = cc.wait(t, 2)
+	if got := serviceConfig(state); got != valid {
+		t.Errorf("service config after invalid = %q, want %q", got, valid)
+	}
+	if got := addrs(state); got != "127.0.0.1:8000,127.0.0.1:8001" {
+		t.Errorf("addresses = %s", got)
+	}
+	//删除配置后恢复客户端的默认配置
+	del(t, cli, s, serviceConfigKey)
+	state = cc.wait(t, 3)
+	if state.ServiceConfig != nil {
+		t.Errorf("service config after delete = %q, want nil", serviceConfig(state))
+	}
+}
+
+func TestRelist(t *testing.T) {
+	s, cc, cli := newTestDiscovery(t)
+	defer cli.Close()
+	defer s.Close()
+	put(t, cli, s, "127.0.0.1:8000", "1")
+	put(t, cli, s, "127.0.0.1:8001", "2")
+	//本地服务列表遗漏了8001的新增和9000的删除，灰度比例遗漏了删除
+	s.setServiceList(s.prefix+"127.0.0.1:8000", "1")
+	s.setServiceList(s.prefix+"127.0.0.1:9000", "1")
+	s.setConfig(s.prefix+canaryKey, "10")
+
+	if _, err := s.relist(); err != nil {
+		t.Fatalf("relist: %v", err)
+	}
+	s.flush()
+	state := cc.wait(t, 1)
+	if got := addrs(state); got != "127.0.0.1:8000,127.0.0.1:8001" {
+		t.Errorf("addresses = %s, want 127.0.0.1:8000,127.0.0.1:8001", got)
+	}
+	if p := s.split.Percent(); p != 0 {
+		t.Errorf("canary percent = %d, want 0", p)
+	}
+}
+
+func TestResolveNow(t *testing.T) {
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	s, cc, cli := newTestDiscovery(t)
+	defer cli.Close()
+	defer s.Close()
+	put(t, cli, s, "127.0.0.1:8000", "1")
+	//Build只新增服务地址，遗漏删除的9000由重新拉取修正
+	s.setServiceList(s.prefix+"127.0.0.1:9000", "1")
+	build(t, s, cc)
+	if got := addrs(cc.wait(t, 1)); got != "127.0.0.1:8000,127.0.0.1:9000" {
+		t.Fatalf("addresses = %s", got)
+	}
+
+	//间隔内的多次ResolveNow合并为一次重新拉取
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.ResolveNow(resolver.ResolveNowOption{})
+		time.Sleep(100 * time.Millisecond)
+	}
+	deadline := time.Now().Add(resolveNowInterval + 5*time.Second)
+	for cc.count() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for relist")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < resolveNowInterval-time.Second {
+		t.Errorf("relist after %v, want about %v", elapsed, resolveNowInterval)
+	}
+	if got := addrs(cc.wait(t, 2)); got != "127.0.0.1:8000" {
+		t.Errorf("addresses after relist = %s, want 127.0.0.1:8000", got)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if n := logs.count("relist prefix:" + s.prefix + ","); n != 1 {
+		t.Errorf("relisted %d times, want 1", n)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const throttle = 300 * time.Millisecond
+	s, cc, cli := newTestDiscovery(t, WithThrottle(throttle))
+	defer cli.Close()
+	defer s.Close()
+	build(t, s, cc)
+	cc.wait(t, 1)
+
+	//一个节流间隔内的多个变更合并为一次推送
+	for _, addr := range []string{"127.0.0.1:8000", "127.0.0.1:8001", "127.0.0.1:8002"} {
+		put(t, cli, s, addr, "1")
+	}
+	del(t, cli, s, "127.0.0.1:8001")
+	state := cc.wait(t, 2)
+	if got := addrs(state); got != "127.0.0.1:8000,127.0.0.1:8002" {
+		t.Errorf("addresses = %s, want 127.0.0.1:8000,127.0.0.1:8002", got)
+	}
+	time.Sleep(2 * throttle)
+	if n := cc.count(); n != 2 {
+		t.Errorf("got %d updates, want 2", n)
+	}
+}
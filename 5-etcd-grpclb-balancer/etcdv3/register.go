@@ -2,6 +2,7 @@ package etcdv3
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"time"
 
@@ -18,6 +19,22 @@ type ServiceRegister struct {
 	weight        string //value
 }
 
+//NodeInfo 服务节点信息，以JSON格式注册到etcd
+type NodeInfo struct {
+	Weight  int    `json:"weight"`            //权重
+	Version string `json:"version,omitempty"` //版本号
+	Label   string `json:"label,omitempty"`   //标签，canary为灰度实例
+}
+
+//NewServiceRegisterWithInfo 新建注册服务，注册服务节点的权重、版本和标签
+func NewServiceRegisterWithInfo(endpoints []string, addr string, info NodeInfo, lease int64) (*ServiceRegister, error) {
+	val, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	return NewServiceRegister(endpoints, addr, string(val), lease)
+}
+
 //NewServiceRegister 新建注册服务
 func NewServiceRegister(endpoints []string, addr, weigit string, lease int64) (*ServiceRegister, error) {
 	cli, err := clientv3.New(clientv3.Config{
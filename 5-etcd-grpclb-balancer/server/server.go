@@ -4,11 +4,14 @@ import (
 	"context"
 	"log"
 	"net"
+	"time"
 
+	"github.com/coreos/etcd/clientv3"
 	"google.golang.org/grpc"
 
 	"etcd-example/5-etcd-grpclb-balancer/etcdv3"
 	pb "etcd-example/5-etcd-grpclb-balancer/proto"
+	"etcd-example/6-etcd-mutex/lock"
 )
 
 // SimpleService 定义我们的服务
@@ -21,6 +24,8 @@ const (
 	Network string = "tcp"
 	// SerName 服务名称
 	SerName string = "simple_grpc"
+	// FenceKey 写请求需要持有的锁前缀
+	FenceKey string = "/lock/simple_grpc"
 )
 
 // EtcdEndpoints etcd地址
@@ -33,8 +38,13 @@ func main() {
 		log.Fatalf("net.Listen err: %v", err)
 	}
 	log.Println(Address + " net.Listing...")
-	// 新建gRPC服务器实例
-	grpcServer := grpc.NewServer()
+	cli, err := clientv3.New(clientv3.Config{Endpoints: EtcdEndpoints, DialTimeout: 5 * time.Second})
+	if err != nil {
+		log.Fatalf("connect etcd err: %v", err)
+	}
+	defer cli.Close()
+	// 新建gRPC服务器实例，拒绝携带过期栅栏令牌的请求
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(lock.NewFenceValidator(cli, FenceKey).UnaryServerInterceptor()))
 	// 在gRPC服务器注册我们的服务
 	pb.RegisterSimpleServer(grpcServer, &SimpleService{})
 	//把服务注册到etcd
@@ -0,0 +1,228 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+)
+
+const (
+	//defaultTTL 默认的session租约时间(秒)
+	defaultTTL = 10
+	//defaultRetryPeriod 默认的重新竞选间隔
+	defaultRetryPeriod = time.Second
+)
+
+//ErrNotLeader 不是leader
+var ErrNotLeader = errors.New("election: not leader")
+
+//Callbacks 领导权变化时的回调
+type Callbacks struct {
+	//OnStartedLeading 成为leader时在新协程中调用，ctx在失去领导权时被取消，
+	//ctx取消后应尽快返回，返回后才会调用OnStoppedLeading
+	OnStartedLeading func(ctx context.Context)
+	//OnStoppedLeading 失去领导权时调用
+	OnStoppedLeading func()
+	//OnNewLeader 观察到leader变化时调用，参数为新leader的身份标识，没有leader时为空字符串
+	OnNewLeader func(identity string)
+}
+
+//Option 选举的可选配置
+type Option func(*Elector)
+
+//WithTTL 设置session租约时间(秒)，leader崩溃后最多ttl秒重新选举
+func WithTTL(ttl int) Option {
+	return func(e *Elector) {
+		e.ttl = ttl
+	}
+}
+
+//WithRetryPeriod 设置失去领导权或竞选出错后重新竞选的间隔
+func WithRetryPeriod(d time.Duration) Option {
+	return func(e *Elector) {
+		e.retryPeriod = d
+	}
+}
+
+//Elector 基于concurrency.Election的leader选举，以identity作为节点身份参与竞选，
+//session丢失后自动重新竞选
+type Elector struct {
+	cli         *clientv3.Client
+	pfx         string
+	identity    string //节点身份标识
+	callbacks   Callbacks
+	ttl         int
+	retryPeriod time.Duration
+
+	mu     sync.Mutex
+	leader string             //当前观察到的leader
+	resign context.CancelFunc //成为leader期间有效，调用后放弃领导权
+}
+
+//NewElector 新建选举，pfx为选举前缀，identity为节点身份标识
+func NewElector(cli *clientv3.Client, pfx, identity string, callbacks Callbacks, opts ...Option) *Elector {
+	e := &Elector{
+		cli:         cli,
+		pfx:         pfx,
+		identity:    identity,
+		callbacks:   callbacks,
+		ttl:         defaultTTL,
+		retryPeriod: defaultRetryPeriod,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+//Run 参与竞选，阻塞直到ctx取消。失去领导权或session丢失后重新竞选
+func (e *Elector) Run(ctx context.Context) error {
+	go e.observe(ctx)
+	for {
+		if err := e.campaign(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("election %s campaign err: %v", e.pfx, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.retryPeriod):
+		}
+	}
+}
+
+//Resign 主动放弃领导权，之后重新参与竞选
+func (e *Elector) Resign() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.resign == nil {
+		return ErrNotLeader
+	}
+	e.resign()
+	return nil
+}
+
+//IsLeader 是否为leader
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.resign != nil
+}
+
+//Leader 返回当前观察到的leader身份标识，没有leader时返回空字符串
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+//campaign 创建session竞选，成为leader后执行回调直到失去领导权
+func (e *Elector) campaign(ctx context.Context) error {
+	s, err := concurrency.NewSession(e.cli, concurrency.WithTTL(e.ttl), concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	//session丢失时取消竞选和领导权
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.Done():
+			cancel()
+		case <-sctx.Done():
+		}
+	}()
+
+	el := concurrency.NewElection(s, e.pfx)
+	if err := el.Campaign(sctx, e.identity); err != nil {
+		return err
+	}
+	log.Printf("election %s: %s started leading", e.pfx, e.identity)
+	e.mu.Lock()
+	e.resign = cancel
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if e.callbacks.OnStartedLeading != nil {
+			e.callbacks.OnStartedLeading(sctx)
+		}
+	}()
+	<-sctx.Done()
+	<-done
+
+	e.mu.Lock()
+	e.resign = nil
+	e.mu.Unlock()
+	log.Printf("election %s: %s stopped leading", e.pfx, e.identity)
+	if e.callbacks.OnStoppedLeading != nil {
+		e.callbacks.OnStoppedLeading()
+	}
+	//session仍有效时删除leader key，让其他节点尽快当选
+	rctx, rcancel := context.WithTimeout(context.Background(), time.Duration(e.ttl)*time.Second)
+	defer rcancel()
+	return el.Resign(rctx)
+}
+
+//observe 观察leader变化，出错后重新观察
+func (e *Elector) observe(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := e.observeOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("election %s observe err: %v", e.pfx, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(e.retryPeriod):
+		}
+	}
+}
+
+//observeOnce 监听选举前缀，每次变化后以创建版本号最小的key作为leader。
+//只读取不写入，不需要session；leader放弃领导权且没有继任者时清空leader
+func (e *Elector) observeOnce(ctx context.Context) error {
+	pfx := e.pfx + "/"
+	resp, err := e.cli.Get(ctx, pfx, clientv3.WithFirstCreate()...)
+	if err != nil {
+		return err
+	}
+	e.setLeaderFrom(resp)
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := e.cli.Watch(wctx, pfx, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		resp, err := e.cli.Get(ctx, pfx, clientv3.WithFirstCreate()...)
+		if err != nil {
+			return err
+		}
+		e.setLeaderFrom(resp)
+	}
+	return ctx.Err()
+}
+
+//setLeaderFrom 以响应中的第一个key作为leader，没有key时清空leader
+func (e *Elector) setLeaderFrom(resp *clientv3.GetResponse) {
+	if len(resp.Kvs) == 0 {
+		e.setLeader("")
+		return
+	}
+	e.setLeader(string(resp.Kvs[0].Value))
+}
+
+func (e *Elector) setLeader(identity string) {
+	e.mu.Lock()
+	changed := e.leader != identity
+	e.leader = identity
+	e.mu.Unlock()
+	if changed && e.callbacks.OnNewLeader != nil {
+		e.callbacks.OnNewLeader(identity)
+	}
+}
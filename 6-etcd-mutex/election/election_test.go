@@ -0,0 +1,94 @@
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//waitFor 等待cond成立，5秒内不成立时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElector(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	pfx := etcdtest.Prefix(t)
+
+	stopped := make(chan string, 2)
+	run := func(identity string) (*Elector, context.CancelFunc, <-chan error) {
+		e := NewElector(cli, pfx, identity, Callbacks{
+			OnStartedLeading: func(ctx context.Context) { <-ctx.Done() },
+			OnStoppedLeading: func() { stopped <- identity },
+		}, WithTTL(5), WithRetryPeriod(100*time.Millisecond))
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- e.Run(ctx) }()
+		return e, cancel, errc
+	}
+
+	a, cancelA, errA := run("a")
+	waitFor(t, "a leading", a.IsLeader)
+	b, cancelB, errB := run("b")
+	waitFor(t, "b observing a", func() bool { return b.Leader() == "a" })
+	if b.IsLeader() {
+		t.Fatal("b is leader while a is leading")
+	}
+	if err := b.Resign(); err != ErrNotLeader {
+		t.Fatalf("Resign by follower = %v, want %v", err, ErrNotLeader)
+	}
+
+	//a放弃领导权后排队的b当选
+	if err := a.Resign(); err != nil {
+		t.Fatalf("Resign: %v", err)
+	}
+	if who := <-stopped; who != "a" {
+		t.Fatalf("OnStoppedLeading called for %s, want a", who)
+	}
+	waitFor(t, "b leading", b.IsLeader)
+	waitFor(t, "a observing b", func() bool { return a.Leader() == "b" })
+
+	//停止b后a重新当选
+	cancelB()
+	if err := <-errB; err != context.Canceled {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+	if who := <-stopped; who != "b" {
+		t.Fatalf("OnStoppedLeading called for %s, want b", who)
+	}
+	waitFor(t, "a leading again", a.IsLeader)
+
+	//a的session租约丢失后失去领导权，重新竞选后再次当选
+	resp, err := cli.Get(context.Background(), pfx+"/", clientv3.WithFirstCreate()...)
+	if err != nil || len(resp.Kvs) == 0 {
+		t.Fatalf("Get leader key: %v", err)
+	}
+	if _, err := cli.Revoke(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	select {
+	case who := <-stopped:
+		if who != "a" {
+			t.Fatalf("OnStoppedLeading called for %s, want a", who)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for OnStoppedLeading after lease revoke")
+	}
+	waitFor(t, "a leading after session lost", a.IsLeader)
+	cancelA()
+	if err := <-errA; err != context.Canceled {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+var (
+	//ErrBarrierHeld 屏障已被设置
+	ErrBarrierHeld = errors.New("lock: barrier already held")
+	//ErrTooManyParticipants 进入双屏障的参与者超过设定数量
+	ErrTooManyParticipants = errors.New("lock: too many participants")
+)
+
+//Barrier 分布式屏障。Hold设置屏障后，所有Wait阻塞到Release删除屏障key。
+//屏障key不绑定租约，设置者崩溃后需要手动Release
+type Barrier struct {
+	cli *clientv3.Client
+	key string
+}
+
+//NewBarrier 新建屏障，key为屏障key
+func NewBarrier(cli *clientv3.Client, key string) *Barrier {
+	return &Barrier{cli: cli, key: key}
+}
+
+//Hold 设置屏障，屏障已存在时返回ErrBarrierHeld
+func (b *Barrier) Hold(ctx context.Context) error {
+	resp, err := b.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(b.key), "=", 0)).
+		Then(clientv3.OpPut(b.key, "")).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrBarrierHeld
+	}
+	return nil
+}
+
+//Release 删除屏障，唤醒所有等待者
+func (b *Barrier) Release(ctx context.Context) error {
+	_, err := b.cli.Delete(ctx, b.key)
+	return err
+}
+
+//Wait 阻塞直到屏障被删除或ctx取消，屏障不存在时立即返回
+func (b *Barrier) Wait(ctx context.Context) error {
+	resp, err := b.cli.Get(ctx, b.key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	return waitDelete(ctx, b.cli, b.key, resp.Header.Revision+1)
+}
+
+//DoubleBarrier 分布式双屏障，count个参与者都Enter后才能开始计算，
+//都Leave后才能离开，用于多个worker同步完成一个阶段后再开始下一阶段
+type DoubleBarrier struct {
+	cli   *clientv3.Client
+	pfx   string
+	count int
+	opts  options
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	key     string //参与者的key
+}
+
+//NewDoubleBarrier 新建前缀为pfx、参与者数量为count的双屏障
+func NewDoubleBarrier(cli *clientv3.Client, pfx string, count int, opts ...Option) *DoubleBarrier {
+	return &DoubleBarrier{
+		cli:   cli,
+		pfx:   pfx + "/",
+		count: count,
+		opts:  newOptions(opts),
+	}
+}
+
+//Enter 进入双屏障，阻塞直到count个参与者都进入或ctx取消
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.session != nil {
+		return ErrAlreadyLocked
+	}
+	//参与者key绑定session租约，参与者崩溃后key自动删除
+	s, err := newSession(ctx, b.cli, b.opts.ttl)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%swaiters/%x", b.pfx, s.Lease())
+	if _, err := b.cli.Put(ctx, key, b.opts.holderInfo(time.Time{}), clientv3.WithLease(s.Lease())); err != nil {
+		s.Close()
+		return err
+	}
+	resp, err := b.cli.Get(ctx, b.pfx+"waiters/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		s.Close()
+		return err
+	}
+	if resp.Count > int64(b.count) {
+		s.Close()
+		return ErrTooManyParticipants
+	}
+	if resp.Count == int64(b.count) {
+		//最后一个参与者设置ready key，唤醒所有参与者
+		_, err = b.cli.Put(ctx, b.pfx+"ready", "")
+	} else {
+		err = b.waitReady(ctx, resp.Header.Revision+1)
+	}
+	if err != nil {
+		s.Close()
+		return err
+	}
+	b.session = s
+	b.key = key
+	return nil
+}
+
+//Leave 离开双屏障，阻塞直到所有参与者都离开或ctx取消
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.session == nil {
+		return ErrNotLocked
+	}
+	for {
+		resp, err := b.cli.Get(ctx, b.pfx+"waiters/", clientv3.WithPrefix(),
+			clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			break
+		}
+		lowest, highest := resp.Kvs[0], resp.Kvs[len(resp.Kvs)-1]
+		if len(resp.Kvs) == 1 && string(lowest.Key) == b.key {
+			//最后一个离开的参与者删除自己的key和ready key
+			_, err := b.cli.Txn(ctx).Then(clientv3.OpDelete(b.key), clientv3.OpDelete(b.pfx+"ready")).Commit()
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if string(lowest.Key) == b.key {
+			//排在最前面的参与者等待最后一个参与者离开
+			err = waitDelete(ctx, b.cli, string(highest.Key), resp.Header.Revision+1)
+		} else {
+			//其他参与者删除自己的key，等待排在最前面的参与者离开
+			if _, err := b.cli.Delete(ctx, b.key); err != nil {
+				return err
+			}
+			err = waitDelete(ctx, b.cli, string(lowest.Key), resp.Header.Revision+1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := b.session.Close()
+	b.session, b.key = nil, ""
+	return err
+}
+
+//waitReady 等待ready key被创建
+func (b *DoubleBarrier) waitReady(ctx context.Context, rev int64) error {
+	resp, err := b.cli.Get(ctx, b.pfx+"ready")
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) > 0 {
+		return nil
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := b.cli.Watch(wctx, b.pfx+"ready", clientv3.WithRev(rev))
+	for wresp := range wch {
+		//watch出错(如起始版本已被压缩)时返回错误，不能当作ctx取消
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.PUT {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
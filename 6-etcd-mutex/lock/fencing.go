@@ -0,0 +1,142 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//fenceKeyMD gRPC metadata中锁前缀的key
+	fenceKeyMD = "fencing-key"
+	//fenceTokenMD gRPC metadata中栅栏令牌的key
+	fenceTokenMD = "fencing-token"
+)
+
+var (
+	//ErrStaleToken 栅栏令牌小于已见过的最大令牌，说明持有者已失去锁
+	ErrStaleToken = errors.New("lock: stale fencing token")
+	//ErrUnknownFenceKey 锁前缀不在校验器的校验范围内
+	ErrUnknownFenceKey = errors.New("lock: unknown fencing key")
+)
+
+//Fence 栅栏令牌。锁的持有者在session过期后仍可能写入下游存储，
+//下游通过拒绝小于已见过最大值的令牌来防止过期持有者的写入
+type Fence struct {
+	Key   string //锁前缀
+	Token int64  //锁key的创建版本号
+}
+
+type fenceKey struct{}
+
+func withFence(ctx context.Context, f Fence) context.Context {
+	return context.WithValue(ctx, fenceKey{}, f)
+}
+
+//FenceFromContext 从Locked返回的ctx中获取栅栏令牌
+func FenceFromContext(ctx context.Context) (Fence, bool) {
+	f, ok := ctx.Value(fenceKey{}).(Fence)
+	return f, ok
+}
+
+//OutgoingFence 把栅栏令牌添加到gRPC请求的metadata中
+func OutgoingFence(ctx context.Context, f Fence) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, fenceKeyMD, f.Key, fenceTokenMD, strconv.FormatInt(f.Token, 10))
+}
+
+//IncomingFence 从gRPC请求的metadata中获取栅栏令牌，请求未携带令牌时ok为false
+func IncomingFence(ctx context.Context) (f Fence, ok bool, err error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return Fence{}, false, nil
+	}
+	keys, tokens := md.Get(fenceKeyMD), md.Get(fenceTokenMD)
+	if len(keys) == 0 || len(tokens) == 0 {
+		return Fence{}, false, nil
+	}
+	token, err := strconv.ParseInt(tokens[0], 10, 64)
+	if err != nil {
+		return Fence{}, false, err
+	}
+	return Fence{Key: keys[0], Token: token}, true, nil
+}
+
+//FenceValidator 记录每个锁前缀已见过的最大令牌，拒绝过期的令牌。
+//只校验创建时指定的锁前缀，令牌增大时到etcd确认令牌属于锁的当前持有者，防止伪造的大令牌锁住真正的持有者。
+//已见过的最大令牌只保存在内存中，同一个锁前缀的请求必须由同一个校验器处理：
+//多个副本各自校验时，过期的持有者仍可以写入还没见过新令牌的副本
+type FenceValidator struct {
+	cli  *clientv3.Client
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+//NewFenceValidator 新建栅栏令牌校验器，只接受keys中的锁前缀
+func NewFenceValidator(cli *clientv3.Client, keys ...string) *FenceValidator {
+	v := &FenceValidator{cli: cli, last: make(map[string]int64, len(keys))}
+	for _, key := range keys {
+		v.last[key] = 0
+	}
+	return v
+}
+
+//Validate 校验令牌，令牌小于已见过的最大令牌或不属于锁的当前持有者时返回ErrStaleToken，
+//锁前缀不在校验范围内时返回ErrUnknownFenceKey。
+//同一持有者可多次写入，等于最大令牌视为有效
+func (v *FenceValidator) Validate(ctx context.Context, f Fence) error {
+	v.mu.Lock()
+	last, ok := v.last[f.Key]
+	v.mu.Unlock()
+	if !ok {
+		return ErrUnknownFenceKey
+	}
+	if f.Token <= 0 || f.Token < last {
+		return ErrStaleToken
+	}
+	if f.Token == last {
+		return nil
+	}
+	//令牌增大，确认令牌是锁当前持有者key的创建版本号
+	resp, err := v.cli.Get(ctx, f.Key+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 || resp.Kvs[0].CreateRevision != f.Token {
+		return ErrStaleToken
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	//查询etcd期间可能已接受了更大的令牌
+	if f.Token < v.last[f.Key] {
+		return ErrStaleToken
+	}
+	v.last[f.Key] = f.Token
+	return nil
+}
+
+//UnaryServerInterceptor 返回校验栅栏令牌的gRPC拦截器，未携带令牌的请求直接放行
+func (v *FenceValidator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		f, ok, err := IncomingFence(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid fencing token: %v", err)
+		}
+		if ok {
+			switch err := v.Validate(ctx, f); err {
+			case nil:
+			case ErrStaleToken, ErrUnknownFenceKey:
+				return nil, status.Errorf(codes.FailedPrecondition, "%v: key %s token %d", err, f.Key, f.Token)
+			default:
+				return nil, status.Errorf(codes.Unavailable, "validate fencing token: %v", err)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
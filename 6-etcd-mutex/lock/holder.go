@@ -0,0 +1,110 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+//ErrNoHolder 锁没有持有者
+var ErrNoHolder = errors.New("lock: no holder")
+
+//HolderInfo 持有者信息，以JSON格式存储在锁key的value中
+type HolderInfo struct {
+	Hostname   string    `json:"hostname"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"` //获取锁的时间，排队等待时为零值
+	Purpose    string    `json:"purpose,omitempty"`
+}
+
+//Holder 锁的持有者或排队者
+type Holder struct {
+	Key            string           //锁key
+	Lease          clientv3.LeaseID //锁key绑定的session租约
+	CreateRevision int64            //锁key的创建版本号，决定排队顺序
+	Info           HolderInfo
+}
+
+//holderInfo 返回当前进程的持有者信息
+func (o options) holderInfo(acquiredAt time.Time) string {
+	hostname, _ := os.Hostname()
+	b, _ := json.Marshal(HolderInfo{
+		Hostname:   hostname,
+		PID:        os.Getpid(),
+		AcquiredAt: acquiredAt,
+		Purpose:    o.purpose,
+	})
+	return string(b)
+}
+
+//List 返回前缀为pfx的锁的持有者和排队者，按排队顺序排列。
+//互斥锁的第一个为持有者；读写锁和信号量中排在前面的可能有多个持有者，
+//可通过HolderInfo.AcquiredAt是否为零值区分
+func (l *Locker) List(ctx context.Context, pfx string) ([]Holder, error) {
+	resp, err := l.cli.Get(ctx, pfx+"/", clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	holders := make([]Holder, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		holders = append(holders, newHolder(kv))
+	}
+	return holders, nil
+}
+
+//newHolder 从锁key解析持有者
+func newHolder(kv *mvccpb.KeyValue) Holder {
+	h := Holder{
+		Key:            string(kv.Key),
+		Lease:          clientv3.LeaseID(kv.Lease),
+		CreateRevision: kv.CreateRevision,
+	}
+	//非JSON的value(如concurrency.Mutex创建的空value)只返回key信息
+	json.Unmarshal(kv.Value, &h.Info)
+	return h
+}
+
+//Holder 返回前缀为pfx的锁排在最前面的持有者，没有持有者时返回ErrNoHolder
+func (l *Locker) Holder(ctx context.Context, pfx string) (*Holder, error) {
+	holders, err := l.List(ctx, pfx)
+	if err != nil {
+		return nil, err
+	}
+	if len(holders) == 0 {
+		return nil, ErrNoHolder
+	}
+	return &holders[0], nil
+}
+
+//ForceUnlock 撤销持有者key绑定的租约，强制释放锁，key为List返回的Holder.Key。
+//读写锁和信号量可能同时有多个持有者，需要由调用方明确指定撤销哪一个。
+//同一session的其他key也会被删除，持有者的Locked ctx会在其session发现租约丢失后被取消，
+//返回被撤销的持有者，key不存在时返回ErrNoHolder
+func (l *Locker) ForceUnlock(ctx context.Context, key string) (*Holder, error) {
+	resp, err := l.cli.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNoHolder
+	}
+	h := newHolder(resp.Kvs[0])
+	if h.Lease == clientv3.NoLease {
+		//没有租约的key直接删除，只删除仍是同一个持有者的key
+		_, err = l.cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(h.Key), "=", h.CreateRevision)).
+			Then(clientv3.OpDelete(h.Key)).
+			Commit()
+		return &h, err
+	}
+	if _, err := l.cli.Revoke(ctx, h.Lease); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
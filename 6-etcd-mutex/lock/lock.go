@@ -0,0 +1,260 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+)
+
+//defaultTTL 默认的session租约时间(秒)
+const defaultTTL = 10
+
+var (
+	//ErrLockTimeout 在超时时间内没有获取到锁
+	ErrLockTimeout = errors.New("lock: timeout acquiring lock")
+	//ErrNotLocked 未持有锁
+	ErrNotLocked = errors.New("lock: not locked")
+	//ErrAlreadyLocked 重复上锁
+	ErrAlreadyLocked = errors.New("lock: already locked")
+	//ErrSessionExpired 等待锁期间session租约丢失
+	ErrSessionExpired = errors.New("lock: session expired")
+	//ErrInvalidLimit 信号量的持有者数量小于1
+	ErrInvalidLimit = errors.New("lock: semaphore limit must be at least 1")
+)
+
+//Option 锁的可选配置
+type Option func(*options)
+
+type options struct {
+	ttl        int    //session租约时间(秒)
+	purpose    string //上锁用途，记录在持有者信息中
+	metricName string //监控指标的name标签
+}
+
+//WithTTL 设置session租约时间(秒)，持有者崩溃后最多ttl秒锁自动释放
+func WithTTL(ttl int) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+//WithPurpose 设置上锁用途，记录在锁key的持有者信息中，便于排查
+func WithPurpose(purpose string) Option {
+	return func(o *options) {
+		o.purpose = purpose
+	}
+}
+
+//WithMetricName 设置监控指标的name标签，用于区分单个锁的等待数、获取耗时和丢失次数。
+//name应取自有限的集合(如业务名)，不要使用带ID的锁前缀，避免标签基数无限增长。默认为空
+func WithMetricName(name string) Option {
+	return func(o *options) {
+		o.metricName = name
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{ttl: defaultTTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+//Locker 分布式锁工厂，使用同一个etcd client创建锁
+type Locker struct {
+	cli  *clientv3.Client
+	opts []Option
+}
+
+//NewLocker 新建分布式锁工厂，opts作为创建的所有锁的默认配置
+func NewLocker(cli *clientv3.Client, opts ...Option) *Locker {
+	return &Locker{cli: cli, opts: opts}
+}
+
+//NewMutex 新建前缀为pfx的互斥锁
+func (l *Locker) NewMutex(pfx string, opts ...Option) *Mutex {
+	return NewMutex(l.cli, pfx, append(l.opts[:len(l.opts):len(l.opts)], opts...)...)
+}
+
+//WithLock 获取key的锁后执行fn，fn返回后释放锁。
+//传给fn的ctx继承调用方ctx的值并带有栅栏令牌，在调用方ctx取消或锁丢失(session租约过期)时被取消，
+//fn应在ctx取消后停止工作
+func (l *Locker) WithLock(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	m := l.NewMutex(key)
+	if err := m.Lock(ctx); err != nil {
+		return err
+	}
+	defer m.Unlock(context.Background())
+	locked := m.Locked()
+	fctx, cancel := context.WithCancel(withFence(ctx, m.Fence()))
+	defer cancel()
+	go func() {
+		select {
+		case <-locked.Done():
+			cancel()
+		case <-fctx.Done():
+		}
+	}()
+	return fn(fctx)
+}
+
+//NewReentrantMutex 新建前缀为pfx的可重入互斥锁
+func (l *Locker) NewReentrantMutex(pfx string, opts ...Option) *ReentrantMutex {
+	return NewReentrantMutex(l.cli, pfx, append(l.opts[:len(l.opts):len(l.opts)], opts...)...)
+}
+
+//NewRWMutex 新建前缀为pfx的读写锁
+func (l *Locker) NewRWMutex(pfx string, opts ...Option) *RWMutex {
+	return NewRWMutex(l.cli, pfx, append(l.opts[:len(l.opts):len(l.opts)], opts...)...)
+}
+
+//NewSemaphore 新建前缀为pfx、最多n个持有者的信号量，n小于1时返回ErrInvalidLimit
+func (l *Locker) NewSemaphore(pfx string, n int, opts ...Option) (*Semaphore, error) {
+	return NewSemaphore(l.cli, pfx, n, append(l.opts[:len(l.opts):len(l.opts)], opts...)...)
+}
+
+//Mutex 分布式互斥锁，每次上锁创建独立的session，解锁时关闭session
+type Mutex struct {
+	cli  *clientv3.Client
+	pfx  string
+	opts options
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+	ctx     context.Context    //持有锁期间有效
+	cancel  context.CancelFunc //解锁或锁丢失时取消ctx
+	token   int64              //栅栏令牌
+	//获取锁的时间
+	acquiredAt time.Time
+}
+
+//NewMutex 新建前缀为pfx的互斥锁
+func NewMutex(cli *clientv3.Client, pfx string, opts ...Option) *Mutex {
+	return &Mutex{
+		cli:  cli,
+		pfx:  pfx,
+		opts: newOptions(opts),
+	}
+}
+
+//Lock 上锁，阻塞直到获取锁或ctx取消
+func (m *Mutex) Lock(ctx context.Context) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session != nil {
+		return ErrAlreadyLocked
+	}
+	done := startAcquire(kindMutex, m.opts.metricName)
+	defer func() { done(err) }()
+	//创建session，session的租约会自动续租
+	s, err := newSession(ctx, m.cli, m.opts.ttl)
+	if err != nil {
+		return err
+	}
+	//先写入带持有者信息的key，concurrency.Mutex会复用已存在的key排队
+	key := fmt.Sprintf("%s/%x", m.pfx, s.Lease())
+	if _, err := m.cli.Put(ctx, key, m.opts.holderInfo(time.Time{}), clientv3.WithLease(s.Lease())); err != nil {
+		s.Close()
+		return err
+	}
+	mutex := concurrency.NewMutex(s, m.pfx)
+	if err := mutex.Lock(ctx); err != nil {
+		s.Close()
+		return err
+	}
+	//记录获取锁的时间，锁key的创建版本号作为栅栏令牌
+	resp, err := m.cli.Txn(ctx).If(mutex.IsOwner()).
+		Then(clientv3.OpPut(key, m.opts.holderInfo(time.Now()), clientv3.WithLease(s.Lease())), clientv3.OpGet(key)).
+		Commit()
+	if err == nil && !resp.Succeeded {
+		err = ErrSessionExpired
+	}
+	if err != nil {
+		mutex.Unlock(context.Background())
+		s.Close()
+		return err
+	}
+	m.session = s
+	m.mutex = mutex
+	m.token = resp.Responses[1].GetResponseRange().Kvs[0].CreateRevision
+	m.acquiredAt = time.Now()
+	m.ctx, m.cancel = sessionContext(withFence(context.Background(), m.fence()), s, kindMutex, m.opts.metricName)
+	return nil
+}
+
+//TryLock 在timeout内尝试上锁，超时返回ErrLockTimeout
+func (m *Mutex) TryLock(ctx context.Context, timeout time.Duration) error {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := m.Lock(tctx)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrLockTimeout
+	}
+	return err
+}
+
+//Unlock 解锁并关闭session
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.session == nil {
+		return ErrNotLocked
+	}
+	m.cancel()
+	observeHold(kindMutex, m.opts.metricName, m.acquiredAt)
+	err := m.mutex.Unlock(ctx)
+	//关闭session会撤销租约，即使解锁失败锁也会被释放
+	if cerr := m.session.Close(); err == nil {
+		err = cerr
+	}
+	m.session, m.mutex, m.ctx, m.cancel, m.token = nil, nil, nil, nil, 0
+	return err
+}
+
+//Locked 返回持有锁期间有效的ctx，解锁或session租约丢失时被取消。
+//ctx中带有栅栏令牌，可通过FenceFromContext获取。
+//未持有锁时返回已取消的ctx
+func (m *Mutex) Locked() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx == nil {
+		return canceledContext()
+	}
+	return m.ctx
+}
+
+//Key 返回持有锁的key
+func (m *Mutex) Key() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mutex == nil {
+		return ""
+	}
+	return m.mutex.Key()
+}
+
+//Token 返回栅栏令牌，即锁key的创建版本号，后获取锁的持有者令牌更大。
+//未持有锁时返回0
+func (m *Mutex) Token() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+//Fence 返回锁前缀和栅栏令牌
+func (m *Mutex) Fence() Fence {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fence()
+}
+
+func (m *Mutex) fence() Fence {
+	return Fence{Key: m.pfx, Token: m.token}
+}
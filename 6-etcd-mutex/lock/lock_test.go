@@ -0,0 +1,366 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestMutexTryLock(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	a, b := NewMutex(cli, pfx, WithTTL(5)), NewMutex(cli, pfx, WithTTL(5))
+	if err := a.TryLock(ctx, time.Second); err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	if err := a.Lock(ctx); err != ErrAlreadyLocked {
+		t.Fatalf("Lock again = %v, want %v", err, ErrAlreadyLocked)
+	}
+	if err := b.TryLock(ctx, 100*time.Millisecond); err != ErrLockTimeout {
+		t.Fatalf("TryLock while held = %v, want %v", err, ErrLockTimeout)
+	}
+	token := a.Token()
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := a.Locked().Err(); err == nil {
+		t.Fatal("Locked ctx not canceled after Unlock")
+	}
+	if err := a.Unlock(ctx); err != ErrNotLocked {
+		t.Fatalf("Unlock again = %v, want %v", err, ErrNotLocked)
+	}
+	if err := b.TryLock(ctx, time.Second); err != nil {
+		t.Fatalf("TryLock after Unlock: %v", err)
+	}
+	defer b.Unlock(ctx)
+	//后获取锁的持有者令牌更大
+	if b.Token() <= token {
+		t.Fatalf("Token = %d, want > %d", b.Token(), token)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	pfx := etcdtest.Prefix(t)
+	l := NewLocker(cli, WithTTL(5))
+	err := l.WithLock(context.Background(), pfx, func(ctx context.Context) error {
+		f, ok := FenceFromContext(ctx)
+		if !ok || f.Key != pfx || f.Token <= 0 {
+			t.Errorf("FenceFromContext = %+v, %v", f, ok)
+		}
+		h, err := l.Holder(ctx, pfx)
+		if err != nil {
+			return err
+		}
+		if h.CreateRevision != f.Token || h.Info.AcquiredAt.IsZero() {
+			t.Errorf("Holder = %+v, want token %d", h, f.Token)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithLock: %v", err)
+	}
+	if _, err := l.Holder(context.Background(), pfx); err != ErrNoHolder {
+		t.Fatalf("Holder after WithLock = %v, want %v", err, ErrNoHolder)
+	}
+
+	//传给fn的ctx保留调用方的值，调用方取消时被取消
+	type callerKey struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), callerKey{}, "caller"))
+	defer cancel()
+	err = l.WithLock(parent, pfx, func(ctx context.Context) error {
+		if v, _ := ctx.Value(callerKey{}).(string); v != "caller" {
+			t.Errorf("caller value = %q, want caller", v)
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("ctx not canceled after caller cancel")
+		}
+	})
+	if err != nil {
+		t.Fatalf("WithLock: %v", err)
+	}
+
+	//锁丢失时被取消
+	err = l.WithLock(context.Background(), pfx, func(ctx context.Context) error {
+		h, err := l.Holder(ctx, pfx)
+		if err != nil {
+			return err
+		}
+		if _, err := l.ForceUnlock(context.Background(), h.Key); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("ctx not canceled after lock lost")
+		}
+	})
+	if err != nil {
+		t.Fatalf("WithLock: %v", err)
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	l := NewLocker(cli, WithTTL(5))
+	m := l.NewMutex(pfx)
+	if err := m.Lock(ctx); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	defer m.Unlock(ctx)
+	h, err := l.ForceUnlock(ctx, m.Key())
+	if err != nil {
+		t.Fatalf("ForceUnlock: %v", err)
+	}
+	if h.Key != m.Key() {
+		t.Fatalf("ForceUnlock key = %s, want %s", h.Key, m.Key())
+	}
+	select {
+	case <-m.Locked().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Locked ctx not canceled after ForceUnlock")
+	}
+	if _, err := l.ForceUnlock(ctx, m.Key()); err != ErrNoHolder {
+		t.Fatalf("ForceUnlock again = %v, want %v", err, ErrNoHolder)
+	}
+}
+
+//blocked 检查errc在200毫秒内没有结果，即获取锁仍在阻塞
+func blocked(t *testing.T, what string, errc <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errc:
+		t.Fatalf("%s not blocked: %v", what, err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+//acquired 等待errc的结果，5秒内没有获取锁时测试失败
+func acquired(t *testing.T, what string, errc <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", what)
+	}
+}
+
+func TestRWMutex(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	r1 := NewRWMutex(cli, pfx, WithTTL(5))
+	r2 := NewRWMutex(cli, pfx, WithTTL(5))
+	w := NewRWMutex(cli, pfx, WithTTL(5))
+	r3 := NewRWMutex(cli, pfx, WithTTL(5))
+	async := func(lock func(context.Context) error) <-chan error {
+		errc := make(chan error, 1)
+		go func() { errc <- lock(ctx) }()
+		return errc
+	}
+
+	if err := r1.RUnlock(ctx); err != ErrNotLocked {
+		t.Fatalf("RUnlock without lock = %v, want %v", err, ErrNotLocked)
+	}
+	//两个读者同时持有读锁
+	if err := r1.RLock(ctx); err != nil {
+		t.Fatalf("RLock r1: %v", err)
+	}
+	if err := r1.RLock(ctx); err != ErrAlreadyLocked {
+		t.Fatalf("RLock twice = %v, want %v", err, ErrAlreadyLocked)
+	}
+	if err := r2.RLock(ctx); err != nil {
+		t.Fatalf("RLock r2: %v", err)
+	}
+	//写者等待前面的读者解锁，后来的读者排在等待的写者后面
+	wc := async(w.Lock)
+	blocked(t, "writer", wc)
+	rc := async(r3.RLock)
+	blocked(t, "reader behind writer", rc)
+
+	if err := r1.RUnlock(ctx); err != nil {
+		t.Fatalf("RUnlock r1: %v", err)
+	}
+	blocked(t, "writer", wc)
+	if err := r2.RUnlock(ctx); err != nil {
+		t.Fatalf("RUnlock r2: %v", err)
+	}
+	acquired(t, "writer", wc)
+	blocked(t, "reader behind writer", rc)
+	if err := w.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	acquired(t, "reader behind writer", rc)
+	if err := r3.RUnlock(ctx); err != nil {
+		t.Fatalf("RUnlock r3: %v", err)
+	}
+	if err := w.Unlock(ctx); err != ErrNotLocked {
+		t.Fatalf("Unlock twice = %v, want %v", err, ErrNotLocked)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	if _, err := NewSemaphore(nil, "/sem", 0); err != ErrInvalidLimit {
+		t.Fatalf("NewSemaphore(0) = %v, want %v", err, ErrInvalidLimit)
+	}
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	sems := make([]*Semaphore, 3)
+	for i := range sems {
+		sem, err := NewSemaphore(cli, pfx, 2, WithTTL(5))
+		if err != nil {
+			t.Fatalf("NewSemaphore: %v", err)
+		}
+		sems[i] = sem
+	}
+	for _, sem := range sems[:2] {
+		if err := sem.TryAcquire(ctx, time.Second); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if err := sems[2].TryAcquire(ctx, 100*time.Millisecond); err != ErrLockTimeout {
+		t.Fatalf("TryAcquire over limit = %v, want %v", err, ErrLockTimeout)
+	}
+	//释放一个后排队者获取成功
+	errc := make(chan error, 1)
+	go func() { errc <- sems[2].TryAcquire(ctx, 5*time.Second) }()
+	if err := sems[0].Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("TryAcquire after Release: %v", err)
+	}
+	for _, sem := range sems[1:] {
+		if err := sem.Release(ctx); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+	}
+}
+
+func TestFenceValidator(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	v := NewFenceValidator(cli, pfx)
+	if err := v.Validate(ctx, Fence{Key: pfx + "-other", Token: 1}); err != ErrUnknownFenceKey {
+		t.Fatalf("Validate unknown key = %v, want %v", err, ErrUnknownFenceKey)
+	}
+	a := NewMutex(cli, pfx, WithTTL(5))
+	if err := a.Lock(ctx); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	old := a.Fence()
+	//伪造的大令牌不属于当前持有者
+	if err := v.Validate(ctx, Fence{Key: pfx, Token: old.Token + 1000}); err != ErrStaleToken {
+		t.Fatalf("Validate forged token = %v, want %v", err, ErrStaleToken)
+	}
+	for i := 0; i < 2; i++ {
+		if err := v.Validate(ctx, old); err != nil {
+			t.Fatalf("Validate holder token: %v", err)
+		}
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	b := NewMutex(cli, pfx, WithTTL(5))
+	if err := b.Lock(ctx); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	defer b.Unlock(ctx)
+	if err := v.Validate(ctx, b.Fence()); err != nil {
+		t.Fatalf("Validate new holder token: %v", err)
+	}
+	if err := v.Validate(ctx, old); err != ErrStaleToken {
+		t.Fatalf("Validate old token = %v, want %v", err, ErrStaleToken)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	key := etcdtest.Prefix(t)
+	b := NewBarrier(cli, key)
+	if err := b.Hold(ctx); err != nil {
+		t.Fatalf("Hold: %v", err)
+	}
+	if err := NewBarrier(cli, key).Hold(ctx); err != ErrBarrierHeld {
+		t.Fatalf("Hold twice = %v, want %v", err, ErrBarrierHeld)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- NewBarrier(cli, key).Wait(ctx) }()
+	blocked(t, "Wait", errc)
+	if err := b.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	acquired(t, "Wait after Release", errc)
+	//屏障不存在时立即返回
+	if err := b.Wait(ctx); err != nil {
+		t.Fatalf("Wait without barrier: %v", err)
+	}
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	bs := make([]*DoubleBarrier, 4)
+	for i := range bs {
+		bs[i] = NewDoubleBarrier(cli, pfx, 3, WithTTL(5))
+	}
+	async := func(fn func(context.Context) error) <-chan error {
+		errc := make(chan error, 1)
+		go func() { errc <- fn(ctx) }()
+		return errc
+	}
+
+	//前两个参与者阻塞到第三个进入
+	e0 := async(bs[0].Enter)
+	blocked(t, "Enter 0", e0)
+	e1 := async(bs[1].Enter)
+	blocked(t, "Enter 1", e1)
+	if err := bs[2].Enter(ctx); err != nil {
+		t.Fatalf("Enter 2: %v", err)
+	}
+	acquired(t, "Enter 0", e0)
+	acquired(t, "Enter 1", e1)
+	if err := bs[3].Enter(ctx); err != ErrTooManyParticipants {
+		t.Fatalf("Enter 3 = %v, want %v", err, ErrTooManyParticipants)
+	}
+
+	//前两个参与者阻塞到第三个离开
+	l0 := async(bs[0].Leave)
+	blocked(t, "Leave 0", l0)
+	l1 := async(bs[1].Leave)
+	blocked(t, "Leave 1", l1)
+	if err := bs[2].Leave(ctx); err != nil {
+		t.Fatalf("Leave 2: %v", err)
+	}
+	acquired(t, "Leave 0", l0)
+	acquired(t, "Leave 1", l1)
+	if err := bs[0].Leave(ctx); err != ErrNotLocked {
+		t.Fatalf("Leave twice = %v, want %v", err, ErrNotLocked)
+	}
+}
@@ -0,0 +1,85 @@
+package lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//锁的类型，作为监控指标的kind标签。
+//锁前缀的数量不受限制，监控指标不带锁前缀标签，避免标签基数无限增长；
+//需要区分单个锁时通过WithMetricName设置取值有限的name标签
+const (
+	kindMutex     = "mutex"
+	kindRead      = "read"
+	kindWrite     = "write"
+	kindSemaphore = "semaphore"
+)
+
+var (
+	//acquireDuration 获取锁的耗时
+	acquireDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "etcd_lock",
+		Name:      "acquire_duration_seconds",
+		Help:      "Time spent waiting to acquire the lock.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"kind", "name", "result"})
+	//holdDuration 持有锁的时长
+	holdDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "etcd_lock",
+		Name:      "hold_duration_seconds",
+		Help:      "Time the lock was held before being released or lost.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"kind", "name"})
+	//localWaiters 当前进程中等待获取锁的数量，集群内的等待数量需要汇总所有进程
+	localWaiters = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "etcd_lock",
+		Name:      "local_waiters",
+		Help:      "Number of callers in this process currently waiting to acquire a lock.",
+	}, []string{"kind", "name"})
+	//lostTotal 持有期间session租约丢失的次数
+	lostTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_lock",
+		Name:      "lost_total",
+		Help:      "Number of times the lock was lost because its session lease expired.",
+	}, []string{"kind", "name"})
+)
+
+//Register 把锁的监控指标注册到reg，不注册时不导出指标
+func Register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{acquireDuration, holdDuration, localWaiters, lostTotal} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//startAcquire 开始等待锁，返回的函数在获取结束时调用，记录获取耗时和结果
+func startAcquire(kind, name string) func(err error) {
+	start := time.Now()
+	localWaiters.WithLabelValues(kind, name).Inc()
+	return func(err error) {
+		localWaiters.WithLabelValues(kind, name).Dec()
+		acquireDuration.WithLabelValues(kind, name, acquireResult(err)).Observe(time.Since(start).Seconds())
+	}
+}
+
+//observeHold 记录持有锁的时长
+func observeHold(kind, name string, acquiredAt time.Time) {
+	holdDuration.WithLabelValues(kind, name).Observe(time.Since(acquiredAt).Seconds())
+}
+
+func acquireResult(err error) string {
+	switch err {
+	case nil:
+		return "acquired"
+	case context.DeadlineExceeded, ErrLockTimeout:
+		return "timeout"
+	case context.Canceled:
+		return "canceled"
+	default:
+		return "error"
+	}
+}
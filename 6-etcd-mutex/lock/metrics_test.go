@@ -0,0 +1,80 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//sampleCount 返回histogram的样本数
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	//name标签区分本测试的锁，不受其他测试的影响
+	const name = "TestMetrics"
+	a := NewMutex(cli, pfx, WithTTL(5), WithMetricName(name))
+	b := NewMutex(cli, pfx, WithTTL(5), WithMetricName(name))
+	waiters := localWaiters.WithLabelValues(kindMutex, name)
+
+	if err := a.Lock(ctx); err != nil {
+		t.Fatalf("Lock a: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- b.Lock(ctx) }()
+	blocked(t, "Lock b", errc)
+	if n := testutil.ToFloat64(waiters); n != 1 {
+		t.Errorf("local waiters = %v, want 1", n)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock a: %v", err)
+	}
+	acquired(t, "Lock b", errc)
+	if n := testutil.ToFloat64(waiters); n != 0 {
+		t.Errorf("local waiters = %v, want 0", n)
+	}
+	if n := sampleCount(t, acquireDuration.WithLabelValues(kindMutex, name, "acquired")); n != 2 {
+		t.Errorf("acquire count = %d, want 2", n)
+	}
+	if n := sampleCount(t, holdDuration.WithLabelValues(kindMutex, name)); n != 1 {
+		t.Errorf("hold count = %d, want 1", n)
+	}
+
+	//撤销b的session租约，锁丢失
+	resp, err := cli.Get(ctx, b.Key())
+	if err != nil || len(resp.Kvs) == 0 {
+		t.Fatalf("Get %s: %v", b.Key(), err)
+	}
+	if _, err := cli.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	select {
+	case <-b.Locked().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Locked ctx not canceled after lease revoke")
+	}
+	if n := testutil.ToFloat64(lostTotal.WithLabelValues(kindMutex, name)); n != 1 {
+		t.Errorf("lost total = %v, want 1", n)
+	}
+	b.Unlock(ctx)
+	if n := sampleCount(t, holdDuration.WithLabelValues(kindMutex, name)); n != 2 {
+		t.Errorf("hold count after unlock = %d, want 2", n)
+	}
+}
@@ -0,0 +1,124 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+//ErrNoOwner ctx中没有持有者标识
+var ErrNoOwner = errors.New("lock: no owner in context")
+
+type ownerKey struct{}
+
+//WithOwner 返回带有持有者标识的ctx，同一持有者可重复获取ReentrantMutex
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+//OwnerFromContext 获取ctx中的持有者标识
+func OwnerFromContext(ctx context.Context) (string, bool) {
+	owner, ok := ctx.Value(ownerKey{}).(string)
+	return owner, ok && owner != ""
+}
+
+//ReentrantMutex 可重入的分布式互斥锁。持有者由ctx中的标识区分，
+//同一持有者重复上锁只增加持有计数，最后一次解锁时才删除etcd中的锁key。
+//同一进程内的其他持有者先在本地排队，再竞争etcd中的锁
+type ReentrantMutex struct {
+	m     *Mutex
+	local chan struct{} //本地排队，容量为1
+
+	mu    sync.Mutex
+	owner string //当前持有者
+	count int    //持有计数
+}
+
+//NewReentrantMutex 新建前缀为pfx的可重入互斥锁
+func NewReentrantMutex(cli *clientv3.Client, pfx string, opts ...Option) *ReentrantMutex {
+	return &ReentrantMutex{
+		m:     NewMutex(cli, pfx, opts...),
+		local: make(chan struct{}, 1),
+	}
+}
+
+//Lock 上锁，ctx中必须带有持有者标识。持有者已持有锁时增加持有计数并立即返回，
+//已持有的锁因session租约丢失而失效时返回ErrSessionExpired，持有者仍需解锁已有的持有计数
+func (r *ReentrantMutex) Lock(ctx context.Context) error {
+	owner, ok := OwnerFromContext(ctx)
+	if !ok {
+		return ErrNoOwner
+	}
+	r.mu.Lock()
+	if r.count > 0 && r.owner == owner {
+		if r.m.Locked().Err() != nil {
+			r.mu.Unlock()
+			return ErrSessionExpired
+		}
+		r.count++
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	//等待本地其他持有者释放
+	select {
+	case r.local <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := r.m.Lock(ctx); err != nil {
+		<-r.local
+		return err
+	}
+	r.mu.Lock()
+	r.owner, r.count = owner, 1
+	r.mu.Unlock()
+	return nil
+}
+
+//Unlock 解锁，持有计数减为0时删除etcd中的锁key
+func (r *ReentrantMutex) Unlock(ctx context.Context) error {
+	owner, ok := OwnerFromContext(ctx)
+	if !ok {
+		return ErrNoOwner
+	}
+	r.mu.Lock()
+	if r.count == 0 || r.owner != owner {
+		r.mu.Unlock()
+		return ErrNotLocked
+	}
+	r.count--
+	if r.count > 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	r.owner = ""
+	r.mu.Unlock()
+
+	err := r.m.Unlock(ctx)
+	<-r.local
+	return err
+}
+
+//HoldCount 返回owner的持有计数
+func (r *ReentrantMutex) HoldCount(owner string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owner != owner {
+		return 0
+	}
+	return r.count
+}
+
+//Locked 返回持有锁期间有效的ctx，最后一次解锁或session租约丢失时被取消
+func (r *ReentrantMutex) Locked() context.Context {
+	return r.m.Locked()
+}
+
+//Fence 返回锁前缀和栅栏令牌
+func (r *ReentrantMutex) Fence() Fence {
+	return r.m.Fence()
+}
@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestReentrantMutex(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	r := NewReentrantMutex(cli, etcdtest.Prefix(t))
+	a := WithOwner(context.Background(), "a")
+	for i := 0; i < 2; i++ {
+		if err := r.Lock(a); err != nil {
+			t.Fatalf("Lock: %v", err)
+		}
+	}
+	if n := r.HoldCount("a"); n != 2 {
+		t.Fatalf("HoldCount = %d, want 2", n)
+	}
+	//其他持有者在本地排队
+	b, cancel := context.WithTimeout(WithOwner(context.Background(), "b"), 100*time.Millisecond)
+	defer cancel()
+	if err := r.Lock(b); err != context.DeadlineExceeded {
+		t.Fatalf("Lock by other owner = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := r.Lock(context.Background()); err != ErrNoOwner {
+		t.Fatalf("Lock without owner = %v, want %v", err, ErrNoOwner)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.Unlock(a); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+	}
+	if err := r.Locked().Err(); err == nil {
+		t.Fatal("Locked ctx not canceled after last Unlock")
+	}
+	if err := r.Unlock(a); err != ErrNotLocked {
+		t.Fatalf("Unlock not locked = %v, want %v", err, ErrNotLocked)
+	}
+}
+
+func TestReentrantMutexSessionExpired(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	r := NewReentrantMutex(cli, etcdtest.Prefix(t), WithTTL(3))
+	ctx := WithOwner(context.Background(), "a")
+	if err := r.Lock(ctx); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	//撤销session租约模拟锁丢失
+	if _, err := cli.Revoke(ctx, r.m.session.Lease()); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	select {
+	case <-r.Locked().Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Locked ctx not canceled after the session lease was revoked")
+	}
+	if err := r.Lock(ctx); err != ErrSessionExpired {
+		t.Fatalf("reentrant Lock = %v, want %v", err, ErrSessionExpired)
+	}
+	if n := r.HoldCount("a"); n != 1 {
+		t.Fatalf("HoldCount = %d, want 1", n)
+	}
+	//解锁后可以重新获取
+	r.Unlock(ctx)
+	if err := r.Lock(ctx); err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+	if err := r.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+}
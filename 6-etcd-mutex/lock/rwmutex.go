@@ -0,0 +1,124 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+)
+
+//RWMutex 分布式读写锁。读者和写者在前缀下按创建版本号排队，
+//读者只等待排在前面的写者，写者等待排在前面的所有读者和写者，
+//因此多个读者可以同时持有锁，写者不会被后来的读者饿死
+type RWMutex struct {
+	cli  *clientv3.Client
+	pfx  string
+	opts options
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	key     string             //持有锁的key
+	ctx     context.Context    //持有锁期间有效
+	cancel  context.CancelFunc //解锁或锁丢失时取消ctx
+	kind    string             //持有的是读锁还是写锁
+	//获取锁的时间
+	acquiredAt time.Time
+}
+
+//NewRWMutex 新建前缀为pfx的读写锁
+func NewRWMutex(cli *clientv3.Client, pfx string, opts ...Option) *RWMutex {
+	return &RWMutex{
+		cli:  cli,
+		pfx:  pfx + "/",
+		opts: newOptions(opts),
+	}
+}
+
+//RLock 上读锁，阻塞直到排在前面的写者都解锁或ctx取消
+func (rw *RWMutex) RLock(ctx context.Context) error {
+	return rw.lock(ctx, kindRead, rw.pfx+"write/")
+}
+
+//Lock 上写锁，阻塞直到排在前面的读者和写者都解锁或ctx取消
+func (rw *RWMutex) Lock(ctx context.Context) error {
+	return rw.lock(ctx, kindWrite, rw.pfx)
+}
+
+//RUnlock 解读锁并关闭session
+func (rw *RWMutex) RUnlock(ctx context.Context) error {
+	return rw.unlock(ctx)
+}
+
+//Unlock 解写锁并关闭session
+func (rw *RWMutex) Unlock(ctx context.Context) error {
+	return rw.unlock(ctx)
+}
+
+//Locked 返回持有锁期间有效的ctx，解锁或session租约丢失时被取消。
+//未持有锁时返回已取消的ctx
+func (rw *RWMutex) Locked() context.Context {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.ctx == nil {
+		return canceledContext()
+	}
+	return rw.ctx
+}
+
+//lock 在pfx/kind/下创建key排队，等待waitPfx下排在前面的key都删除
+func (rw *RWMutex) lock(ctx context.Context, kind, waitPfx string) (err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.session != nil {
+		return ErrAlreadyLocked
+	}
+	done := startAcquire(kind, rw.opts.metricName)
+	defer func() { done(err) }()
+	s, err := newSession(ctx, rw.cli, rw.opts.ttl)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s/%x", rw.pfx, kind, s.Lease())
+	resp, err := rw.cli.Put(ctx, key, rw.opts.holderInfo(time.Time{}), clientv3.WithLease(s.Lease()))
+	if err != nil {
+		s.Close()
+		return err
+	}
+	//key是新创建的，put的版本号即创建版本号
+	if err := waitDeletes(ctx, rw.cli, waitPfx, resp.Header.Revision-1); err != nil {
+		//关闭session会撤销租约并删除key
+		s.Close()
+		return err
+	}
+	//记录获取锁的时间
+	if _, err := rw.cli.Put(ctx, key, rw.opts.holderInfo(time.Now()), clientv3.WithLease(s.Lease())); err != nil {
+		s.Close()
+		return err
+	}
+	rw.session = s
+	rw.key = key
+	rw.kind = kind
+	rw.acquiredAt = time.Now()
+	rw.ctx, rw.cancel = sessionContext(context.Background(), s, kind, rw.opts.metricName)
+	return nil
+}
+
+func (rw *RWMutex) unlock(ctx context.Context) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.session == nil {
+		return ErrNotLocked
+	}
+	rw.cancel()
+	observeHold(rw.kind, rw.opts.metricName, rw.acquiredAt)
+	_, err := rw.cli.Delete(ctx, rw.key)
+	//关闭session会撤销租约，即使删除失败锁也会被释放
+	if cerr := rw.session.Close(); err == nil {
+		err = cerr
+	}
+	rw.session, rw.key, rw.ctx, rw.cancel = nil, "", nil, nil
+	return err
+}
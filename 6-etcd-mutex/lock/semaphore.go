@@ -0,0 +1,178 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+//Semaphore 分布式计数信号量，集群内最多n个持有者。
+//获取者在前缀下创建绑定session租约的key，创建版本号排在前n位时获取成功。
+//同一前缀的所有获取者应使用相同的n
+type Semaphore struct {
+	cli  *clientv3.Client
+	pfx  string
+	n    int
+	opts options
+
+	mu      sync.Mutex
+	session *concurrency.Session
+	key     string             //持有信号量的key
+	ctx     context.Context    //持有期间有效
+	cancel  context.CancelFunc //释放或session租约丢失时取消ctx
+	//获取信号量的时间
+	acquiredAt time.Time
+}
+
+//NewSemaphore 新建前缀为pfx、最多n个持有者的信号量，n小于1时返回ErrInvalidLimit
+func NewSemaphore(cli *clientv3.Client, pfx string, n int, opts ...Option) (*Semaphore, error) {
+	if n < 1 {
+		return nil, ErrInvalidLimit
+	}
+	return &Semaphore{
+		cli:  cli,
+		pfx:  pfx + "/",
+		n:    n,
+		opts: newOptions(opts),
+	}, nil
+}
+
+//Acquire 获取信号量，阻塞直到获取成功、ctx取消或session租约丢失
+func (sem *Semaphore) Acquire(ctx context.Context) (err error) {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if sem.session != nil {
+		return ErrAlreadyLocked
+	}
+	done := startAcquire(kindSemaphore, sem.opts.metricName)
+	defer func() { done(err) }()
+	s, err := newSession(ctx, sem.cli, sem.opts.ttl)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%x", sem.pfx, s.Lease())
+	resp, err := sem.cli.Put(ctx, key, sem.opts.holderInfo(time.Time{}), clientv3.WithLease(s.Lease()))
+	if err != nil {
+		s.Close()
+		return err
+	}
+	if err := sem.wait(ctx, s, resp.Header.Revision); err != nil {
+		//关闭session会撤销租约并删除key
+		s.Close()
+		return err
+	}
+	//记录获取信号量的时间
+	if _, err := sem.cli.Put(ctx, key, sem.opts.holderInfo(time.Now()), clientv3.WithLease(s.Lease())); err != nil {
+		s.Close()
+		return err
+	}
+	sem.session = s
+	sem.key = key
+	sem.acquiredAt = time.Now()
+	sem.ctx, sem.cancel = sessionContext(context.Background(), s, kindSemaphore, sem.opts.metricName)
+	return nil
+}
+
+//TryAcquire 在timeout内尝试获取信号量，超时返回ErrLockTimeout
+func (sem *Semaphore) TryAcquire(ctx context.Context, timeout time.Duration) error {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := sem.Acquire(tctx)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrLockTimeout
+	}
+	return err
+}
+
+//Release 释放信号量并关闭session
+func (sem *Semaphore) Release(ctx context.Context) error {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if sem.session == nil {
+		return ErrNotLocked
+	}
+	sem.cancel()
+	observeHold(kindSemaphore, sem.opts.metricName, sem.acquiredAt)
+	_, err := sem.cli.Delete(ctx, sem.key)
+	//关闭session会撤销租约，即使删除失败信号量也会被释放
+	if cerr := sem.session.Close(); err == nil {
+		err = cerr
+	}
+	sem.session, sem.key, sem.ctx, sem.cancel = nil, "", nil, nil
+	return err
+}
+
+//Locked 返回持有信号量期间有效的ctx，释放或session租约丢失时被取消。
+//未持有时返回已取消的ctx
+func (sem *Semaphore) Locked() context.Context {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if sem.ctx == nil {
+		return canceledContext()
+	}
+	return sem.ctx
+}
+
+//wait 等待创建版本号为myRev的key排到前n位
+func (sem *Semaphore) wait(ctx context.Context, s *concurrency.Session, myRev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for {
+		//按创建版本号取前n个key
+		resp, err := sem.cli.Get(wctx, sem.pfx, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+			clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend), clientv3.WithLimit(int64(sem.n)))
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.Kvs {
+			if kv.CreateRevision == myRev {
+				return nil
+			}
+		}
+		//自己的key已被删除，说明租约已丢失
+		if len(resp.Kvs) < sem.n {
+			return ErrSessionExpired
+		}
+		//等待前n个key中有key被删除后再检查
+		if err := sem.waitDelete(wctx, s, resp.Kvs, resp.Header.Revision+1); err != nil {
+			return err
+		}
+	}
+}
+
+//waitDelete 等待kvs中任意一个key在rev之后被删除
+func (sem *Semaphore) waitDelete(ctx context.Context, s *concurrency.Session, kvs []*mvccpb.KeyValue, rev int64) error {
+	ahead := make(map[string]bool, len(kvs))
+	for _, kv := range kvs {
+		ahead[string(kv.Key)] = true
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := sem.cli.Watch(wctx, sem.pfx, clientv3.WithPrefix(), clientv3.WithRev(rev), clientv3.WithFilterPut())
+	for {
+		select {
+		case wresp, ok := <-wch:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return context.Canceled
+			}
+			if err := wresp.Err(); err != nil {
+				return err
+			}
+			for _, ev := range wresp.Events {
+				if ahead[string(ev.Kv.Key)] {
+					return nil
+				}
+			}
+		case <-s.Done():
+			return ErrSessionExpired
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lock
+
+import (
+	"context"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+//newSession 使用ctx申请ttl秒的租约并创建session。
+//concurrency.NewSession使用client的ctx申请租约，etcd不可用时会忽略调用方的ctx一直阻塞
+func newSession(ctx context.Context, cli *clientv3.Client, ttl int) (*concurrency.Session, error) {
+	resp, err := cli.Grant(ctx, int64(ttl))
+	if err != nil {
+		return nil, err
+	}
+	s, err := concurrency.NewSession(cli, concurrency.WithLease(resp.ID), concurrency.WithTTL(ttl))
+	if err != nil {
+		cli.Revoke(context.Background(), resp.ID)
+		return nil, err
+	}
+	return s, nil
+}
+
+//sessionContext 返回session租约丢失时被取消的ctx，租约丢失时记录kind类型锁的丢失次数
+func sessionContext(parent context.Context, s *concurrency.Session, kind, name string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-s.Done():
+			//解锁时先取消ctx再关闭session，ctx未取消说明锁丢失
+			if ctx.Err() == nil {
+				lostTotal.WithLabelValues(kind, name).Inc()
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+//canceledContext 返回已取消的ctx
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+//waitDelete 等待key在rev之后被删除
+func waitDelete(ctx context.Context, cli *clientv3.Client, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := cli.Watch(wctx, key, clientv3.WithRev(rev))
+	for wresp := range wch {
+		//watch出错(如起始版本已被压缩)时返回错误，不能当作ctx取消
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
+
+//waitDeletes 等待前缀pfx下所有创建版本号不大于maxCreateRev的key被删除
+func waitDeletes(ctx context.Context, cli *clientv3.Client, pfx string, maxCreateRev int64) error {
+	getOpts := append(clientv3.WithLastCreate(), clientv3.WithMaxCreateRev(maxCreateRev))
+	for {
+		resp, err := cli.Get(ctx, pfx, getOpts...)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+		//等待排在前面的最后一个key删除后再检查
+		if err := waitDelete(ctx, cli, string(resp.Kvs[0].Key), resp.Header.Revision); err != nil {
+			return err
+		}
+	}
+}
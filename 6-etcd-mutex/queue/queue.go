@@ -0,0 +1,273 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const (
+	//defaultVisibilityTimeout 默认的消息可见性超时
+	defaultVisibilityTimeout = 30 * time.Second
+	//batchSize 每次读取的候选消息数量
+	batchSize = 64
+)
+
+//ErrClaimExpired 消息的可见性超时已过，消息可能已被其他消费者取走
+var ErrClaimExpired = errors.New("queue: claim expired")
+
+//Option 队列的可选配置
+type Option func(*queue)
+
+//WithVisibilityTimeout 设置消息可见性超时，取出的消息在超时内没有Ack会重新对其他消费者可见
+func WithVisibilityTimeout(d time.Duration) Option {
+	return func(q *queue) {
+		q.visibility = d
+	}
+}
+
+//Message 从队列取出的消息
+type Message struct {
+	Key      string //消息key
+	Value    string //消息内容
+	Priority int    //优先级，FIFO队列为0
+
+	claimKey string           //认领key
+	claimRev int64            //认领key的创建版本号
+	lease    clientv3.LeaseID //认领key绑定的租约
+}
+
+//queue 基于etcd的持久化队列。消息存储在pfx/items/<优先级>/<序号>下，按key排序出队；
+//取出消息时在pfx/claims/下创建绑定租约的认领key，租约过期后消息重新可见，
+//Ack时才删除消息，消费者崩溃不会丢失消息
+type queue struct {
+	cli        *clientv3.Client
+	itemsPfx   string
+	claimsPfx  string
+	visibility time.Duration
+}
+
+func newQueue(cli *clientv3.Client, pfx string, opts []Option) queue {
+	q := queue{
+		cli:        cli,
+		itemsPfx:   pfx + "/items/",
+		claimsPfx:  pfx + "/claims/",
+		visibility: defaultVisibilityTimeout,
+	}
+	for _, opt := range opts {
+		opt(&q)
+	}
+	return q
+}
+
+//Queue FIFO队列
+type Queue struct {
+	queue
+}
+
+//NewQueue 新建前缀为pfx的FIFO队列
+func NewQueue(cli *clientv3.Client, pfx string, opts ...Option) *Queue {
+	return &Queue{newQueue(cli, pfx, opts)}
+}
+
+//Enqueue 消息入队
+func (q *Queue) Enqueue(ctx context.Context, val string) error {
+	return q.enqueue(ctx, val, 0)
+}
+
+//PriorityQueue 优先级队列，priority越小越先出队，相同优先级FIFO
+type PriorityQueue struct {
+	queue
+}
+
+//NewPriorityQueue 新建前缀为pfx的优先级队列
+func NewPriorityQueue(cli *clientv3.Client, pfx string, opts ...Option) *PriorityQueue {
+	return &PriorityQueue{newQueue(cli, pfx, opts)}
+}
+
+//Enqueue 以priority优先级入队
+func (q *PriorityQueue) Enqueue(ctx context.Context, val string, priority uint16) error {
+	return q.enqueue(ctx, val, int(priority))
+}
+
+//enqueue 在优先级前缀下创建序号递增的key
+func (q *queue) enqueue(ctx context.Context, val string, priority int) error {
+	pfx := fmt.Sprintf("%s%05d/", q.itemsPfx, priority)
+	for {
+		//取该优先级下最后一个key的序号加1
+		resp, err := q.cli.Get(ctx, pfx, clientv3.WithLastKey()...)
+		if err != nil {
+			return err
+		}
+		var seq int64
+		if len(resp.Kvs) > 0 {
+			last, err := strconv.ParseInt(strings.TrimPrefix(string(resp.Kvs[0].Key), pfx), 10, 64)
+			if err != nil {
+				return err
+			}
+			seq = last + 1
+		}
+		key := fmt.Sprintf("%s%016d", pfx, seq)
+		//key已被其他生产者创建时重试
+		txn, err := q.cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, val)).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+}
+
+//Dequeue 取出队首的消息，队列为空时阻塞直到有消息或ctx取消。
+//消息在可见性超时内需要Ack，否则会重新出队
+func (q *queue) Dequeue(ctx context.Context) (*Message, error) {
+	for {
+		m, rev, err := q.claim(ctx)
+		if err != nil || m != nil {
+			return m, err
+		}
+		//没有可用消息，等待新消息入队或认领过期
+		if err := q.wait(ctx, rev+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//Ack 确认消息处理完成，删除消息
+func (q *queue) Ack(ctx context.Context, m *Message) error {
+	resp, err := q.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(m.claimKey), "=", m.claimRev)).
+		Then(clientv3.OpDelete(m.Key), clientv3.OpDelete(m.claimKey)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	q.cli.Revoke(ctx, m.lease)
+	if !resp.Succeeded {
+		return ErrClaimExpired
+	}
+	return nil
+}
+
+//Nack 放弃处理消息，消息立即重新对其他消费者可见
+func (q *queue) Nack(ctx context.Context, m *Message) error {
+	resp, err := q.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(m.claimKey), "=", m.claimRev)).
+		Then(clientv3.OpDelete(m.claimKey)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	q.cli.Revoke(ctx, m.lease)
+	if !resp.Succeeded {
+		return ErrClaimExpired
+	}
+	return nil
+}
+
+//claim 按key顺序查找第一条未被认领的消息并认领，没有可用消息时返回nil和当前版本号。
+//找到候选消息时才申请认领key的租约，可见性超时从认领时开始计算
+func (q *queue) claim(ctx context.Context) (m *Message, rev int64, err error) {
+	lease := clientv3.NoLease
+	defer func() {
+		//租约没有绑定到认领的消息时撤销
+		if lease != clientv3.NoLease && m == nil {
+			q.cli.Revoke(context.Background(), lease)
+		}
+	}()
+	from := q.itemsPfx
+	end := clientv3.GetPrefixRangeEnd(q.itemsPfx)
+	for {
+		resp, err := q.cli.Txn(ctx).Then(
+			clientv3.OpGet(from, clientv3.WithRange(end), clientv3.WithLimit(batchSize),
+				clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)),
+			clientv3.OpGet(q.claimsPfx, clientv3.WithPrefix(), clientv3.WithKeysOnly()),
+		).Commit()
+		if err != nil {
+			return nil, 0, err
+		}
+		items := resp.Responses[0].GetResponseRange()
+		claimed := make(map[string]bool)
+		for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+			claimed[string(kv.Key)] = true
+		}
+		for _, kv := range items.Kvs {
+			claimKey := q.claimsPfx + strings.TrimPrefix(string(kv.Key), q.itemsPfx)
+			if claimed[claimKey] {
+				continue
+			}
+			if lease == clientv3.NoLease {
+				ttl := int64((q.visibility + time.Second - 1) / time.Second)
+				resp, err := q.cli.Grant(ctx, ttl)
+				if err != nil {
+					return nil, 0, err
+				}
+				lease = resp.ID
+			}
+			m, err := q.tryClaim(ctx, kv, claimKey, lease)
+			if err != nil || m != nil {
+				return m, 0, err
+			}
+		}
+		if !items.More {
+			return nil, resp.Header.Revision, nil
+		}
+		//下一批从最后一个key之后开始
+		from = string(items.Kvs[len(items.Kvs)-1].Key) + "\x00"
+	}
+}
+
+//tryClaim 认领消息，消息已被删除或认领时返回nil
+func (q *queue) tryClaim(ctx context.Context, kv *mvccpb.KeyValue, claimKey string, lease clientv3.LeaseID) (*Message, error) {
+	resp, err := q.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(string(kv.Key)), "=", kv.CreateRevision),
+			clientv3.Compare(clientv3.CreateRevision(claimKey), "=", 0)).
+		Then(clientv3.OpPut(claimKey, "", clientv3.WithLease(lease))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		return nil, err
+	}
+	priority, _ := strconv.Atoi(strings.SplitN(strings.TrimPrefix(string(kv.Key), q.itemsPfx), "/", 2)[0])
+	return &Message{
+		Key:      string(kv.Key),
+		Value:    string(kv.Value),
+		Priority: priority,
+		claimKey: claimKey,
+		claimRev: resp.Header.Revision,
+		lease:    lease,
+	}, nil
+}
+
+//wait 等待rev之后有新消息入队或认领key被删除
+func (q *queue) wait(ctx context.Context, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pfx := strings.TrimSuffix(q.itemsPfx, "items/")
+	wch := q.cli.Watch(wctx, pfx, clientv3.WithPrefix(), clientv3.WithRev(rev))
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			key := string(ev.Kv.Key)
+			if (ev.Type == mvccpb.PUT && strings.HasPrefix(key, q.itemsPfx)) ||
+				(ev.Type == mvccpb.DELETE && strings.HasPrefix(key, q.claimsPfx)) {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
@@ -0,0 +1,139 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//dequeue 在1秒内取出一条消息
+func dequeue(t *testing.T, q interface {
+	Dequeue(context.Context) (*Message, error)
+}) *Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	return m
+}
+
+func TestQueue(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	q := NewQueue(cli, etcdtest.Prefix(t))
+	for _, v := range []string{"a", "b", "c"} {
+		if err := q.Enqueue(ctx, v); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	a, b := dequeue(t, q), dequeue(t, q)
+	if a.Value != "a" || b.Value != "b" {
+		t.Fatalf("Dequeue = %s, %s, want a, b", a.Value, b.Value)
+	}
+	//Nack后消息重新出队，排在未取出的消息之前
+	if err := q.Nack(ctx, a); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	if m := dequeue(t, q); m.Value != "a" {
+		t.Fatalf("Dequeue after Nack = %s, want a", m.Value)
+	} else if err := q.Ack(ctx, m); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := q.Ack(ctx, b); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := q.Ack(ctx, b); err != ErrClaimExpired {
+		t.Fatalf("Ack again = %v, want %v", err, ErrClaimExpired)
+	}
+	if m := dequeue(t, q); m.Value != "c" {
+		t.Fatalf("Dequeue = %s, want c", m.Value)
+	}
+}
+
+func TestQueueBlocking(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	q := NewQueue(cli, etcdtest.Prefix(t))
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(tctx); err != context.DeadlineExceeded {
+		t.Fatalf("Dequeue on empty queue = %v, want %v", err, context.DeadlineExceeded)
+	}
+	//队列为空时阻塞到有消息入队
+	type result struct {
+		m   *Message
+		err error
+	}
+	rc := make(chan result, 1)
+	go func() {
+		dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		m, err := q.Dequeue(dctx)
+		rc <- result{m, err}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := q.Enqueue(ctx, "a"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if r := <-rc; r.err != nil || r.m.Value != "a" {
+		t.Fatalf("Dequeue = %+v, %v, want a", r.m, r.err)
+	}
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	q := NewQueue(cli, etcdtest.Prefix(t), WithVisibilityTimeout(time.Second))
+	if err := q.Enqueue(ctx, "a"); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	m := dequeue(t, q)
+	//没有Ack的消息在可见性超时后重新出队
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	again, err := q.Dequeue(dctx)
+	if err != nil {
+		t.Fatalf("Dequeue after visibility timeout: %v", err)
+	}
+	if again.Key != m.Key {
+		t.Fatalf("Dequeue = %s, want %s", again.Key, m.Key)
+	}
+	if err := q.Ack(ctx, m); err != ErrClaimExpired {
+		t.Fatalf("Ack expired claim = %v, want %v", err, ErrClaimExpired)
+	}
+	if err := q.Ack(ctx, again); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	q := NewPriorityQueue(cli, etcdtest.Prefix(t))
+	for _, e := range []struct {
+		val      string
+		priority uint16
+	}{{"low", 10}, {"high-1", 1}, {"high-2", 1}, {"urgent", 0}} {
+		if err := q.Enqueue(ctx, e.val, e.priority); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	for _, want := range []string{"urgent", "high-1", "high-2", "low"} {
+		m := dequeue(t, q)
+		if m.Value != want {
+			t.Fatalf("Dequeue = %s, want %s", m.Value, want)
+		}
+		if err := q.Ack(ctx, m); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+}
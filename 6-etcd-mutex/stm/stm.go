@@ -0,0 +1,132 @@
+package stm
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//事务隔离级别
+const (
+	//Serializable 可串行化，读取的所有key在提交时都未被修改才能提交
+	Serializable = concurrency.Serializable
+	//SerializableSnapshot 可串行化快照，在Serializable基础上要求写入的key在首次读取后未被修改
+	SerializableSnapshot = concurrency.SerializableSnapshot
+	//RepeatableRead 可重复读，同一事务内多次读取同一key结果相同
+	RepeatableRead = concurrency.RepeatableReads
+)
+
+var (
+	//txnTotal 事务提交次数
+	txnTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "etcd_stm",
+		Name:      "txn_total",
+		Help:      "Number of STM transactions by result.",
+	}, []string{"result"})
+	//conflictTotal 事务冲突重试次数
+	conflictTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "etcd_stm",
+		Name:      "conflicts_total",
+		Help:      "Number of STM transaction retries caused by conflicting writes.",
+	})
+)
+
+//Register 把STM的监控指标注册到reg，不注册时不导出指标
+func Register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{txnTotal, conflictTotal} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Option STM的可选配置
+type Option func(*STM)
+
+//WithIsolation 设置事务隔离级别，默认为SerializableSnapshot
+func WithIsolation(iso concurrency.Isolation) Option {
+	return func(s *STM) {
+		s.isolation = iso
+	}
+}
+
+//STM 软件事务内存，在函数内通过Get/Put读写多个key，提交时发生冲突自动重试，
+//避免手写If/Then/Else事务
+type STM struct {
+	cli       *clientv3.Client
+	isolation concurrency.Isolation
+}
+
+//New 新建STM
+func New(cli *clientv3.Client, opts ...Option) *STM {
+	s := &STM{
+		cli:       cli,
+		isolation: SerializableSnapshot,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+//Apply 在事务中执行apply，读取的key被其他客户端修改时重新执行apply。
+//apply可能被执行多次，不应有事务外的副作用
+func (s *STM) Apply(ctx context.Context, apply func(concurrency.STM) error) (*clientv3.TxnResponse, error) {
+	attempts := 0
+	resp, err := concurrency.NewSTM(s.cli, func(stm concurrency.STM) error {
+		//每次重新执行都是因为上一次提交冲突
+		if attempts > 0 {
+			conflictTotal.Inc()
+		}
+		attempts++
+		return apply(stm)
+	}, concurrency.WithAbortContext(ctx), concurrency.WithIsolation(s.isolation))
+	if err != nil {
+		txnTotal.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	txnTotal.WithLabelValues("committed").Inc()
+	return resp, nil
+}
+
+//Increment 原子地把key的整数值加上delta，key不存在时视为0，返回新值
+func (s *STM) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	var n int64
+	_, err := s.Apply(ctx, func(stm concurrency.STM) error {
+		n = 0
+		if v := stm.Get(key); v != "" {
+			var err error
+			if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return err
+			}
+		}
+		n += delta
+		stm.Put(key, strconv.FormatInt(n, 10))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+//CompareAndSwap key的值等于old时原子地设置为new，返回是否设置成功。
+//old为空字符串表示key不存在
+func (s *STM) CompareAndSwap(ctx context.Context, key, old, new string) (bool, error) {
+	var swapped bool
+	_, err := s.Apply(ctx, func(stm concurrency.STM) error {
+		swapped = stm.Get(key) == old
+		if swapped {
+			stm.Put(key, new)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
@@ -0,0 +1,146 @@
+package stm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestApply(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	pfx := etcdtest.Prefix(t) + "/"
+	ctx := context.Background()
+	cli.Put(ctx, pfx+"a", "100")
+	//在a和b之间转账，两个key在同一个事务中修改
+	_, err := New(cli).Apply(ctx, func(stm concurrency.STM) error {
+		stm.Put(pfx+"a", "70")
+		stm.Put(pfx+"b", "30")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	for key, want := range map[string]string{"a": "70", "b": "30"} {
+		resp, err := cli.Get(ctx, pfx+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != want {
+			t.Errorf("%s = %v, want %s", key, resp.Kvs, want)
+		}
+	}
+
+	//apply返回错误时不提交
+	errAbort := fmt.Errorf("abort")
+	_, err = New(cli).Apply(ctx, func(stm concurrency.STM) error {
+		stm.Put(pfx+"a", "0")
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Apply = %v, want %v", err, errAbort)
+	}
+	if resp, _ := cli.Get(ctx, pfx+"a"); string(resp.Kvs[0].Value) != "70" {
+		t.Errorf("a = %s after aborted Apply, want 70", resp.Kvs[0].Value)
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	key := etcdtest.Prefix(t) + "/counter"
+	ctx := context.Background()
+	conflicts := testutil.ToFloat64(conflictTotal)
+	attempts := 0
+	_, err := New(cli).Apply(ctx, func(stm concurrency.STM) error {
+		v := stm.Get(key)
+		attempts++
+		if attempts == 1 {
+			//第一次执行时其他客户端修改了读取的key，提交冲突后重新执行
+			if _, err := cli.Put(ctx, key, "other"); err != nil {
+				return err
+			}
+		}
+		stm.Put(key, v+"+stm")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("apply executed %d times, want 2", attempts)
+	}
+	if got := testutil.ToFloat64(conflictTotal) - conflicts; got != 1 {
+		t.Errorf("conflicts_total increased by %v, want 1", got)
+	}
+	if resp, _ := cli.Get(ctx, key); string(resp.Kvs[0].Value) != "other+stm" {
+		t.Errorf("value = %s, want other+stm", resp.Kvs[0].Value)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	key := etcdtest.Prefix(t) + "/counter"
+	s := New(cli)
+	//并发增加，冲突时重试，结果不丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Increment(context.Background(), key, 1); err != nil {
+				t.Errorf("Increment: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := s.Increment(context.Background(), key, -3)
+	if err != nil {
+		t.Fatalf("Increment: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("Increment = %d, want 7", n)
+	}
+
+	//非整数的值返回错误
+	cli.Put(context.Background(), key, "abc")
+	if _, err := s.Increment(context.Background(), key, 1); err == nil {
+		t.Error("Increment non-integer value: want error")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	key := etcdtest.Prefix(t) + "/leader"
+	s := New(cli)
+	tests := []struct {
+		old, new string
+		want     bool
+	}{
+		//old为空表示key不存在
+		{old: "", new: "a", want: true},
+		{old: "", new: "b", want: false},
+		{old: "b", new: "c", want: false},
+		{old: "a", new: "c", want: true},
+	}
+	for _, tt := range tests {
+		got, err := s.CompareAndSwap(context.Background(), key, tt.old, tt.new)
+		if err != nil {
+			t.Fatalf("CompareAndSwap(%q, %q): %v", tt.old, tt.new, err)
+		}
+		if got != tt.want {
+			t.Errorf("CompareAndSwap(%q, %q) = %v, want %v", tt.old, tt.new, got, tt.want)
+		}
+	}
+	if resp, _ := cli.Get(context.Background(), key); string(resp.Kvs[0].Value) != "c" {
+		t.Errorf("value = %s, want c", resp.Kvs[0].Value)
+	}
+}
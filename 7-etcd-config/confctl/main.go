@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"etcd-example/7-etcd-config/config"
+)
+
+const usage = `usage: confctl [flags] <command> [args]
+
+commands:
+  history <key>              列出key的历史版本
+  diff <key> <rev1> [rev2]   比较两个版本，rev2默认为当前版本
+  rollback <key> <rev>       把key回滚到指定版本
+
+flags:
+`
+
+func main() {
+	endpoints := flag.String("endpoints", "localhost:2379", "etcd地址，多个以逗号分隔")
+	limit := flag.Int("limit", 20, "history列出的最大版本数")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(*endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key := args[1]
+	switch args[0] {
+	case "history":
+		revs, err := config.History(ctx, cli, key, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range revs {
+			fmt.Printf("revision:%d version:%d\n%s\n\n", r.Revision, r.Version, r.Value)
+		}
+	case "diff":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		a, err := config.ValueAt(ctx, cli, key, parseRev(args[2]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		var rev2 int64 //0为当前版本
+		if len(args) > 3 {
+			rev2 = parseRev(args[3])
+		}
+		b, err := config.ValueAt(ctx, cli, key, rev2)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("--- revision %d\n+++ revision %d\n%s", a.Revision, b.Revision, config.Diff(a.Value, b.Value))
+	case "rollback":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		resp, err := config.Rollback(ctx, cli, key, parseRev(args[2]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("rolled back %s to revision %s, new revision %d\n", key, args[2], resp.Header.Revision)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func parseRev(s string) int64 {
+	var rev int64
+	if _, err := fmt.Sscan(s, &rev); err != nil {
+		log.Fatalf("invalid revision %q: %v", s, err)
+	}
+	return rev
+}
@@ -0,0 +1,317 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Format 配置的编码格式
+type Format int
+
+const (
+	//JSON JSON格式
+	JSON Format = iota
+	//YAML YAML格式
+	YAML
+)
+
+const (
+	//minRetryInterval 重新读取失败后的最小重试间隔
+	minRetryInterval = 100 * time.Millisecond
+	//maxRetryInterval 重新读取失败后的最大重试间隔
+	maxRetryInterval = 30 * time.Second
+)
+
+//ErrNotLoaded 配置尚未加载
+var ErrNotLoaded = errors.New("config: not loaded")
+
+//Option 配置的可选配置
+type Option func(*Config)
+
+//WithFormat 设置配置的编码格式，默认为JSON
+func WithFormat(format Format) Option {
+	return func(c *Config) {
+		c.format = format
+	}
+}
+
+//WithPerKey 前缀下每个key存储结构体的一个字段，key名为字段的json tag。
+//默认前缀本身的key存储整个配置文档
+func WithPerKey() Option {
+	return func(c *Config) {
+		c.perKey = true
+	}
+}
+
+//WithValidator 设置校验函数，新配置校验通过后才会替换当前配置
+func WithValidator(validate func(v interface{}) error) Option {
+	return func(c *Config) {
+		c.validate = validate
+	}
+}
+
+//Config 配置中心，把etcd前缀绑定到Go结构体，监听变更并热更新。
+//新配置解析或校验失败时保留上一个有效的版本。
+//结构体字段使用json tag，YAML格式也按json tag解析
+type Config struct {
+	cli      *clientv3.Client
+	pfx      string
+	newValue func() interface{} //创建配置结构体指针
+	format   Format
+	perKey   bool
+	validate func(v interface{}) error
+
+	raw map[string][]byte //前缀下的原始value，只在Load和watch协程中修改
+
+	mu          sync.RWMutex
+	value       interface{} //当前有效的配置
+	rev         int64       //当前有效配置的版本号
+	subscribers []func(old, new interface{})
+}
+
+//New 新建配置中心，newValue返回配置结构体的指针，如func() interface{} { return &AppConfig{} }
+func New(cli *clientv3.Client, pfx string, newValue func() interface{}, opts ...Option) *Config {
+	c := &Config{
+		cli:      cli,
+		pfx:      pfx,
+		newValue: newValue,
+		format:   JSON,
+		raw:      make(map[string][]byte),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+//Start 加载配置并在后台监听变更，ctx取消后停止监听。首次加载的配置无效时返回错误
+func (c *Config) Start(ctx context.Context) error {
+	rev, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	go c.watch(ctx, rev+1)
+	return nil
+}
+
+//Get 返回当前有效的配置，调用方不应修改返回的结构体
+func (c *Config) Get() interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value
+}
+
+//Rev 返回当前有效配置的版本号
+func (c *Config) Rev() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rev
+}
+
+//Subscribe 订阅配置变更，配置替换后以旧配置和新配置调用fn
+func (c *Config) Subscribe(fn func(old, new interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers = append(c.subscribers, fn)
+}
+
+//load 读取前缀下所有key并应用，返回读取时的版本号
+func (c *Config) load(ctx context.Context) (int64, error) {
+	rev, err := c.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.apply(rev); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+//fetch 读取前缀下所有key替换原始value，返回读取时的版本号
+func (c *Config) fetch(ctx context.Context) (int64, error) {
+	opts := []clientv3.OpOption{}
+	if c.perKey {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := c.cli.Get(ctx, c.key(), opts...)
+	if err != nil {
+		return 0, err
+	}
+	c.raw = make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		c.raw[string(kv.Key)] = kv.Value
+	}
+	return resp.Header.Revision, nil
+}
+
+//watch 监听前缀变更，每个watch响应应用一次
+func (c *Config) watch(ctx context.Context, rev int64) {
+	backoff := minRetryInterval
+	for {
+		if c.watchFrom(ctx, rev) {
+			backoff = minRetryInterval
+		}
+		//watch中断(如版本已被压缩)时等待一段时间后重新读取，读取失败时加倍等待时间
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxRetryInterval {
+				backoff = maxRetryInterval
+			}
+			fetched, err := c.fetch(ctx)
+			if err != nil {
+				log.Printf("config %s reload err: %v", c.pfx, err)
+				continue
+			}
+			//新配置无效时保留当前配置，仍从读取的版本继续监听，不再重复读取无效的版本
+			if err := c.apply(fetched); err != nil {
+				log.Printf("config %s rev %d rejected, keep rev %d: %v", c.pfx, fetched, c.Rev(), err)
+			}
+			rev = fetched + 1
+			break
+		}
+	}
+}
+
+//watchFrom 从rev开始监听，watch出错或中断时返回，返回是否收到过watch响应
+func (c *Config) watchFrom(ctx context.Context, rev int64) bool {
+	opts := []clientv3.OpOption{clientv3.WithRev(rev)}
+	if c.perKey {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	//返回时取消watch，避免出错后继续占用watch
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	received := false
+	for wresp := range c.cli.Watch(wctx, c.key(), opts...) {
+		if err := wresp.Err(); err != nil {
+			log.Printf("config %s watch err: %v", c.pfx, err)
+			return received
+		}
+		received = true
+		for _, ev := range wresp.Events {
+			switch ev.Type {
+			case mvccpb.PUT:
+				c.raw[string(ev.Kv.Key)] = ev.Kv.Value
+			case mvccpb.DELETE:
+				delete(c.raw, string(ev.Kv.Key))
+			}
+		}
+		if err := c.apply(wresp.Header.Revision); err != nil {
+			log.Printf("config %s rev %d rejected, keep rev %d: %v", c.pfx, wresp.Header.Revision, c.Rev(), err)
+		}
+	}
+	return received
+}
+
+//apply 解析、校验原始value，通过后替换当前配置并通知订阅者
+func (c *Config) apply(rev int64) error {
+	v, err := c.decode()
+	if err != nil {
+		return err
+	}
+	if c.validate != nil {
+		if err := c.validate(v); err != nil {
+			return fmt.Errorf("config: validate: %v", err)
+		}
+	}
+	c.mu.Lock()
+	old := c.value
+	c.value, c.rev = v, rev
+	subscribers := c.subscribers
+	c.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(old, v)
+	}
+	return nil
+}
+
+//decode 把原始value解析为配置结构体
+func (c *Config) decode() (interface{}, error) {
+	v := c.newValue()
+	if !c.perKey {
+		if len(c.raw) == 0 {
+			return nil, ErrNotLoaded
+		}
+		b, err := c.toJSON(c.raw[c.key()])
+		if err != nil {
+			return nil, fmt.Errorf("config: decode %s: %v", c.key(), err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("config: decode %s: %v", c.key(), err)
+		}
+		return v, nil
+	}
+	//每个key是一个字段，合并为一个JSON对象后解析
+	fields := make(map[string]json.RawMessage, len(c.raw))
+	for key, val := range c.raw {
+		b, err := c.toJSON(val)
+		if err != nil {
+			return nil, fmt.Errorf("config: decode %s: %v", key, err)
+		}
+		fields[strings.TrimPrefix(key, c.key())] = b
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("config: decode: %v", err)
+	}
+	return v, nil
+}
+
+//toJSON 把value转换为JSON
+func (c *Config) toJSON(val []byte) ([]byte, error) {
+	if c.format == JSON {
+		if !json.Valid(val) {
+			return nil, errors.New("invalid json")
+		}
+		return val, nil
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(val, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(yamlToJSON(v))
+}
+
+//key 文档模式下为配置key，字段模式下为字段key的前缀
+func (c *Config) key() string {
+	if c.perKey {
+		return c.pfx + "/"
+	}
+	return c.pfx
+}
+
+//yamlToJSON 把yaml解析出的map[interface{}]interface{}转换为JSON可编码的map[string]interface{}
+func yamlToJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = yamlToJSON(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = yamlToJSON(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
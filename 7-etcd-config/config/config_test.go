@@ -0,0 +1,123 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+type appConfig struct {
+	Addr    string            `json:"addr"`
+	Workers int               `json:"workers"`
+	Labels  map[string]string `json:"labels"`
+}
+
+//waitRev 等待配置更新到rev之后，5秒内没有更新时测试失败
+func waitRev(t *testing.T, c *Config, rev int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Rev() < rev {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for rev %d, got %d", rev, c.Rev())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := etcdtest.Prefix(t)
+	c := New(cli, key, func() interface{} { return &appConfig{} },
+		WithFormat(YAML),
+		WithValidator(func(v interface{}) error {
+			if v.(*appConfig).Workers <= 0 {
+				return errors.New("workers must be positive")
+			}
+			return nil
+		}))
+	changes := make(chan *appConfig, 4)
+	c.Subscribe(func(old, new interface{}) { changes <- new.(*appConfig) })
+
+	if err := c.Start(ctx); err != ErrNotLoaded {
+		t.Fatalf("Start without config = %v, want %v", err, ErrNotLoaded)
+	}
+	if _, err := cli.Put(ctx, key, "addr: :8080\nworkers: 0\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err == nil {
+		t.Fatal("Start with invalid config succeeded")
+	}
+	if _, err := cli.Put(ctx, key, "addr: :8080\nworkers: 4\nlabels:\n  zone: a\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	want := &appConfig{Addr: ":8080", Workers: 4, Labels: map[string]string{"zone": "a"}}
+	if got := c.Get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get = %+v, want %+v", got, want)
+	}
+
+	//校验失败的配置被拒绝，保留上一个有效的配置
+	if _, err := cli.Put(ctx, key, "addr: :9090\nworkers: -1\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.Put(ctx, key, "addr: :9090\nworkers: 8\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRev(t, c, resp.Header.Revision)
+	if got := c.Get().(*appConfig); got.Addr != ":9090" || got.Workers != 8 {
+		t.Fatalf("Get = %+v, want addr :9090 workers 8", got)
+	}
+	for _, want := range []int{4, 8} {
+		select {
+		case got := <-changes:
+			if got.Workers != want {
+				t.Fatalf("subscriber got workers %d, want %d", got.Workers, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscriber not called for workers %d", want)
+		}
+	}
+	if len(changes) != 0 {
+		t.Fatalf("subscriber got %d extra changes", len(changes))
+	}
+}
+
+func TestConfigPerKey(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := etcdtest.Prefix(t)
+	if _, err := cli.Put(ctx, key+"/addr", `":8080"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Put(ctx, key+"/workers", "4"); err != nil {
+		t.Fatal(err)
+	}
+	c := New(cli, key, func() interface{} { return &appConfig{} }, WithPerKey())
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := c.Get().(*appConfig); got.Addr != ":8080" || got.Workers != 4 {
+		t.Fatalf("Get = %+v, want addr :8080 workers 4", got)
+	}
+	//删除字段key后字段恢复为零值
+	resp, err := cli.Delete(ctx, key+"/workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRev(t, c, resp.Header.Revision)
+	if got := c.Get().(*appConfig); got.Addr != ":8080" || got.Workers != 0 {
+		t.Fatalf("Get = %+v, want addr :8080 workers 0", got)
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+var (
+	//ErrRevisionCompacted 版本已被压缩，无法读取
+	ErrRevisionCompacted = errors.New("config: revision has been compacted")
+	//ErrKeyNotFound 指定版本时key不存在
+	ErrKeyNotFound = errors.New("config: key not found")
+	//ErrConflict 回滚期间key被其他客户端修改
+	ErrConflict = errors.New("config: key modified concurrently")
+)
+
+//Revision key的一个历史版本
+type Revision struct {
+	Revision int64  //修改该版本的版本号(ModRevision)
+	Version  int64  //key自创建以来的修改次数
+	Value    string //该版本的value
+}
+
+//ValueAt 返回key在rev时的版本，rev已被压缩时返回ErrRevisionCompacted
+func ValueAt(ctx context.Context, cli *clientv3.Client, key string, rev int64) (Revision, error) {
+	resp, err := cli.Get(ctx, key, clientv3.WithRev(rev))
+	if err == rpctypes.ErrCompacted {
+		return Revision{}, fmt.Errorf("%w: %s at revision %d", ErrRevisionCompacted, key, rev)
+	}
+	if err != nil {
+		return Revision{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return Revision{}, fmt.Errorf("%w: %s at revision %d", ErrKeyNotFound, key, rev)
+	}
+	kv := resp.Kvs[0]
+	return Revision{Revision: kv.ModRevision, Version: kv.Version, Value: string(kv.Value)}, nil
+}
+
+//History 从新到旧列出key当前生命周期内最多limit个历史版本，limit<=0时不限制。
+//到达压缩边界或key的创建版本时停止，被删除前的旧生命周期不会列出
+func History(ctx context.Context, cli *clientv3.Client, key string, limit int) ([]Revision, error) {
+	resp, err := cli.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	kv := resp.Kvs[0]
+	revs := []Revision{{Revision: kv.ModRevision, Version: kv.Version, Value: string(kv.Value)}}
+	for (limit <= 0 || len(revs) < limit) && revs[len(revs)-1].Version > 1 {
+		//上一个版本在当前版本的前一个版本号时的值
+		r, err := ValueAt(ctx, cli, key, revs[len(revs)-1].Revision-1)
+		if errors.Is(err, ErrRevisionCompacted) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, nil
+}
+
+//Rollback 把key回滚到rev时的value。通过比较当前ModRevision的事务写入，
+//期间key被修改时返回ErrConflict，rev已被压缩时返回ErrRevisionCompacted
+func Rollback(ctx context.Context, cli *clientv3.Client, key string, rev int64) (*clientv3.TxnResponse, error) {
+	old, err := ValueAt(ctx, cli, key, rev)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := cli.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	//key当前不存在时ModRevision为0
+	var modRev int64
+	if len(cur.Kvs) > 0 {
+		modRev = cur.Kvs[0].ModRevision
+	}
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRev)).
+		Then(clientv3.OpPut(key, old.Value)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Succeeded {
+		return nil, ErrConflict
+	}
+	return resp, nil
+}
+
+//Diff 按行比较a和b，返回以"-"、"+"和" "开头的差异行
+func Diff(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	//lcs[i][j]为x[i:]和y[j:]的最长公共子序列长度
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			sb.WriteString("  " + x[i] + "\n")
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + x[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + y[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
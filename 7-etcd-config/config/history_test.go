@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "相同", a: "a\nb", b: "a\nb", want: "  a\n  b\n"},
+		{name: "新增行", a: "a\nc", b: "a\nb\nc", want: "  a\n+ b\n  c\n"},
+		{name: "删除行", a: "a\nb\nc", b: "a\nc", want: "  a\n- b\n  c\n"},
+		{name: "修改行", a: "port: 80\nhost: a", b: "port: 8080\nhost: a", want: "- port: 80\n+ port: 8080\n  host: a\n"},
+		{name: "从空到有", a: "", b: "a", want: "- \n+ a\n"},
+		{name: "末尾追加", a: "a", b: "a\nb\nc", want: "  a\n+ b\n+ c\n"},
+		{name: "全部不同", a: "a\nb", b: "c", want: "- a\n- b\n+ c\n"},
+	}
+	for _, tt := range tests {
+		if got := Diff(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: Diff(%q, %q) = %q, want %q", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	key := etcdtest.Prefix(t)
+	v1, err := cli.Put(ctx, key, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Put(ctx, key, "v2")
+	if _, err := Rollback(ctx, cli, key, v1.Header.Revision); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	revs, err := History(ctx, cli, key, 0)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	var values []string
+	for _, r := range revs {
+		values = append(values, r.Value)
+	}
+	if got := strings.Join(values, ","); got != "v1,v2,v1" {
+		t.Errorf("History = %s, want v1,v2,v1", got)
+	}
+}
+
+func TestRollbackCompacted(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	key := etcdtest.Prefix(t)
+	v1, err := cli.Put(ctx, key, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := cli.Put(ctx, key, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//压缩后v1无法读取
+	if _, err := cli.Compact(ctx, v2.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rollback(ctx, cli, key, v1.Header.Revision); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("Rollback compacted revision = %v, want %v", err, ErrRevisionCompacted)
+	}
+	//回滚失败时不修改key
+	resp, err := cli.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Kvs[0].Value) != "v2" {
+		t.Errorf("value = %s after failed Rollback, want v2", resp.Kvs[0].Value)
+	}
+}
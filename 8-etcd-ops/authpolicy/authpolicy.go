@@ -0,0 +1,480 @@
+package authpolicy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/auth/authpb"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//rootName etcd开启认证要求的root用户和root角色，prune时不会删除
+const rootName = "root"
+
+//Policy 声明式的认证配置
+type Policy struct {
+	Enable bool   `yaml:"enable" json:"enable"` //应用后开启认证，需要声明root用户
+	Roles  []Role `yaml:"roles" json:"roles"`
+	Users  []User `yaml:"users" json:"users"`
+}
+
+//Role 角色及其权限
+type Role struct {
+	Name        string       `yaml:"name" json:"name"`
+	Permissions []Permission `yaml:"permissions" json:"permissions"`
+}
+
+//Permission key范围的权限。Prefix为true时授权Key前缀，否则授权[Key, RangeEnd)，RangeEnd为空时只授权Key
+type Permission struct {
+	Key      string `yaml:"key" json:"key"`
+	RangeEnd string `yaml:"range_end" json:"range_end"`
+	Prefix   bool   `yaml:"prefix" json:"prefix"`
+	Type     string `yaml:"type" json:"type"` //read|write|readwrite
+}
+
+//User 用户及其角色。Password支持$VAR形式引用环境变量，引用的环境变量未设置或为空时返回错误；
+//为空时不管理已有用户的密码
+type User struct {
+	Name     string   `yaml:"name" json:"name"`
+	Password string   `yaml:"password" json:"password"`
+	Roles    []string `yaml:"roles" json:"roles"`
+}
+
+//Load 读取YAML或JSON格式的配置文件
+func Load(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+//Parse 解析YAML或JSON格式的配置，JSON是YAML的子集
+func Parse(b []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+//validate 检查名称重复、权限类型和用户引用的角色
+func (p *Policy) validate() error {
+	roles := map[string]bool{rootName: true}
+	for _, r := range p.Roles {
+		if r.Name == "" || (roles[r.Name] && r.Name != rootName) {
+			return fmt.Errorf("authpolicy: empty or duplicate role %q", r.Name)
+		}
+		roles[r.Name] = true
+		for _, perm := range r.Permissions {
+			if perm.Key == "" {
+				return fmt.Errorf("authpolicy: role %s: empty permission key", r.Name)
+			}
+			if perm.Prefix && perm.RangeEnd != "" {
+				return fmt.Errorf("authpolicy: role %s: prefix and range_end are exclusive", r.Name)
+			}
+			if _, err := parseType(perm.Type); err != nil {
+				return fmt.Errorf("authpolicy: role %s: %v", r.Name, err)
+			}
+		}
+	}
+	users := make(map[string]bool)
+	for _, u := range p.Users {
+		if u.Name == "" || users[u.Name] {
+			return fmt.Errorf("authpolicy: empty or duplicate user %q", u.Name)
+		}
+		users[u.Name] = true
+		for _, r := range u.Roles {
+			if !roles[r] {
+				return fmt.Errorf("authpolicy: user %s: undeclared role %s", u.Name, r)
+			}
+		}
+	}
+	if p.Enable && !users[rootName] {
+		return fmt.Errorf("authpolicy: enable requires user %s", rootName)
+	}
+	return nil
+}
+
+func parseType(s string) (clientv3.PermissionType, error) {
+	switch s {
+	case "read":
+		return clientv3.PermissionType(clientv3.PermRead), nil
+	case "write":
+		return clientv3.PermissionType(clientv3.PermWrite), nil
+	case "readwrite", "":
+		return clientv3.PermissionType(clientv3.PermReadWrite), nil
+	}
+	return 0, fmt.Errorf("unknown permission type %q", s)
+}
+
+//perm 规范化后的权限，用于比较
+type perm struct {
+	key, rangeEnd string
+	typ           clientv3.PermissionType
+}
+
+func (p Permission) normalize() perm {
+	rangeEnd := p.RangeEnd
+	if p.Prefix {
+		rangeEnd = clientv3.GetPrefixRangeEnd(p.Key)
+	}
+	typ, _ := parseType(p.Type)
+	return perm{key: p.Key, rangeEnd: rangeEnd, typ: typ}
+}
+
+func (p perm) String() string {
+	typ := strings.ToLower(authpb.Permission_Type(p.typ).String())
+	if p.rangeEnd == "" {
+		return fmt.Sprintf("%s %q", typ, p.key)
+	}
+	return fmt.Sprintf("%s [%q, %q)", typ, p.key, p.rangeEnd)
+}
+
+//Change 一项变更
+type Change struct {
+	Op     string //+ 新增，- 删除，~ 修改
+	Kind   string //role|user|permission|grant|auth
+	Name   string
+	Detail string
+	do     func(ctx context.Context, cli *clientv3.Client) error
+}
+
+func (c Change) String() string {
+	if c.Detail == "" {
+		return fmt.Sprintf("%s %s %s", c.Op, c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s %s %s: %s", c.Op, c.Kind, c.Name, c.Detail)
+}
+
+//state 集群当前的认证配置
+type state struct {
+	authEnabled bool                       //是否已开启认证
+	roles       map[string]map[perm]bool   //角色名 -> 权限
+	users       map[string]map[string]bool //用户名 -> 角色名
+	roleNames   []string                   //按名称排序
+	userNames   []string
+}
+
+//current 读取集群当前的角色、权限和用户
+func current(ctx context.Context, cli *clientv3.Client) (*state, error) {
+	s := &state{roles: make(map[string]map[perm]bool), users: make(map[string]map[string]bool)}
+	enabled, err := authEnabled(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	s.authEnabled = enabled
+	rresp, err := cli.RoleList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.roleNames = rresp.Roles
+	sort.Strings(s.roleNames)
+	for _, name := range rresp.Roles {
+		resp, err := cli.RoleGet(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		perms := make(map[perm]bool)
+		for _, p := range resp.Perm {
+			perms[perm{key: string(p.Key), rangeEnd: string(p.RangeEnd), typ: clientv3.PermissionType(p.PermType)}] = true
+		}
+		s.roles[name] = perms
+	}
+	uresp, err := cli.UserList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.userNames = uresp.Users
+	sort.Strings(s.userNames)
+	for _, name := range uresp.Users {
+		resp, err := cli.UserGet(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		roles := make(map[string]bool)
+		for _, r := range resp.Roles {
+			roles[r] = true
+		}
+		s.users[name] = roles
+	}
+	return s, nil
+}
+
+//Plan 比较配置与集群当前状态，返回需要的变更。
+//prune为true时删除配置中没有声明的用户和角色(root除外)
+func (p *Policy) Plan(ctx context.Context, cli *clientv3.Client, prune bool) ([]Change, error) {
+	s, err := current(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	//先创建角色和权限，用户授权时角色已存在
+	declaredRoles := make(map[string]bool)
+	for _, r := range p.Roles {
+		declaredRoles[r.Name] = true
+		changes = append(changes, planRole(r, s.roles)...)
+	}
+	declaredUsers := make(map[string]bool)
+	for _, u := range p.Users {
+		declaredUsers[u.Name] = true
+		c, err := planUser(ctx, cli, u, s)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+	if prune {
+		//先删除用户再删除角色
+		for _, name := range s.userNames {
+			if !declaredUsers[name] && name != rootName {
+				changes = append(changes, deleteUser(name))
+			}
+		}
+		for _, name := range s.roleNames {
+			if !declaredRoles[name] && name != rootName {
+				changes = append(changes, deleteRole(name))
+			}
+		}
+	}
+	if p.Enable && !s.authEnabled {
+		changes = append(changes, Change{Op: "~", Kind: "auth", Name: "enable",
+			do: func(ctx context.Context, cli *clientv3.Client) error {
+				_, err := cli.AuthEnable(ctx)
+				return err
+			}})
+	}
+	return changes, nil
+}
+
+func planRole(r Role, roles map[string]map[perm]bool) []Change {
+	var changes []Change
+	have, ok := roles[r.Name]
+	if !ok {
+		name := r.Name
+		changes = append(changes, Change{Op: "+", Kind: "role", Name: name,
+			do: func(ctx context.Context, cli *clientv3.Client) error {
+				_, err := cli.RoleAdd(ctx, name)
+				return err
+			}})
+	}
+	want := make(map[perm]bool)
+	for _, p := range r.Permissions {
+		want[p.normalize()] = true
+	}
+	for _, p := range sortedPerms(want) {
+		if !have[p] {
+			c := grantPermission(r.Name, p)
+			if replaced(p, have) {
+				c.Op = "~"
+			}
+			changes = append(changes, c)
+		}
+	}
+	for _, p := range sortedPerms(have) {
+		if want[p] {
+			continue
+		}
+		//相同范围不同类型的权限已被授权覆盖，不需要撤销
+		if replaced(p, want) {
+			continue
+		}
+		changes = append(changes, revokePermission(r.Name, p))
+	}
+	return changes
+}
+
+//replaced 判断perms中是否有相同范围的权限
+func replaced(p perm, perms map[perm]bool) bool {
+	for w := range perms {
+		if w.key == p.key && w.rangeEnd == p.rangeEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func grantPermission(role string, p perm) Change {
+	return Change{Op: "+", Kind: "permission", Name: role, Detail: p.String(),
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.RoleGrantPermission(ctx, role, p.key, p.rangeEnd, p.typ)
+			return err
+		}}
+}
+
+func revokePermission(role string, p perm) Change {
+	return Change{Op: "-", Kind: "permission", Name: role, Detail: p.String(),
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.RoleRevokePermission(ctx, role, p.key, p.rangeEnd)
+			return err
+		}}
+}
+
+func planUser(ctx context.Context, cli *clientv3.Client, u User, s *state) ([]Change, error) {
+	var changes []Change
+	password, err := u.password()
+	if err != nil {
+		return nil, err
+	}
+	have, ok := s.users[u.Name]
+	if !ok {
+		if password == "" {
+			return nil, fmt.Errorf("authpolicy: user %s: empty password", u.Name)
+		}
+		name := u.Name
+		changes = append(changes, Change{Op: "+", Kind: "user", Name: name,
+			do: func(ctx context.Context, cli *clientv3.Client) error {
+				_, err := cli.UserAdd(ctx, name, password)
+				return err
+			}})
+	} else if password != "" && s.authEnabled {
+		//etcd不返回密码，只能在开启认证后通过认证校验
+		same, err := checkPassword(ctx, cli, u.Name, password)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changes = append(changes, changePassword(u.Name, password))
+		}
+	}
+	want := make(map[string]bool)
+	for _, r := range u.Roles {
+		want[r] = true
+	}
+	for _, r := range sortedKeys(want) {
+		if !have[r] {
+			changes = append(changes, grantRole(u.Name, r))
+		}
+	}
+	for _, r := range sortedKeys(have) {
+		if !want[r] {
+			changes = append(changes, revokeRole(u.Name, r))
+		}
+	}
+	return changes, nil
+}
+
+//password 展开密码中引用的环境变量，环境变量未设置或为空时返回错误
+func (u User) password() (string, error) {
+	var missing []string
+	password := os.Expand(u.Password, func(name string) string {
+		v := os.Getenv(name)
+		if v == "" {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("authpolicy: user %s: password references unset environment variable %s", u.Name, strings.Join(missing, ", "))
+	}
+	return password, nil
+}
+
+func changePassword(user, password string) Change {
+	return Change{Op: "~", Kind: "user", Name: user, Detail: "password",
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.UserChangePassword(ctx, user, password)
+			return err
+		}}
+}
+
+//authEnabled 判断集群是否已开启认证。v3.3没有查询认证状态的接口，
+//以空用户名认证，未开启认证时etcd返回ErrAuthNotEnabled，开启后返回ErrAuthFailed
+func authEnabled(ctx context.Context, cli *clientv3.Client) (bool, error) {
+	_, err := pb.NewAuthClient(cli.ActiveConnection()).Authenticate(ctx, &pb.AuthenticateRequest{})
+	switch rpctypes.Error(err) {
+	case rpctypes.ErrAuthNotEnabled:
+		return false, nil
+	case nil, rpctypes.ErrAuthFailed:
+		return true, nil
+	}
+	return false, err
+}
+
+//checkPassword 以用户名和密码认证，判断密码是否正确，需要已开启认证
+func checkPassword(ctx context.Context, cli *clientv3.Client, name, password string) (bool, error) {
+	_, err := pb.NewAuthClient(cli.ActiveConnection()).Authenticate(ctx, &pb.AuthenticateRequest{Name: name, Password: password})
+	switch rpctypes.Error(err) {
+	case nil:
+		return true, nil
+	case rpctypes.ErrAuthFailed:
+		return false, nil
+	}
+	return false, err
+}
+
+func grantRole(user, role string) Change {
+	return Change{Op: "+", Kind: "grant", Name: user, Detail: role,
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.UserGrantRole(ctx, user, role)
+			return err
+		}}
+}
+
+func revokeRole(user, role string) Change {
+	return Change{Op: "-", Kind: "grant", Name: user, Detail: role,
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.UserRevokeRole(ctx, user, role)
+			return err
+		}}
+}
+
+func deleteUser(name string) Change {
+	return Change{Op: "-", Kind: "user", Name: name,
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.UserDelete(ctx, name)
+			return err
+		}}
+}
+
+func deleteRole(name string) Change {
+	return Change{Op: "-", Kind: "role", Name: name,
+		do: func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.RoleDelete(ctx, name)
+			return err
+		}}
+}
+
+//Apply 按顺序执行变更，返回已执行的变更数。
+//中途失败时重新Plan会得到剩余的变更
+func Apply(ctx context.Context, cli *clientv3.Client, changes []Change) (int, error) {
+	for i, c := range changes {
+		if err := c.do(ctx, cli); err != nil {
+			return i, fmt.Errorf("authpolicy: %v: %v", c, err)
+		}
+	}
+	return len(changes), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPerms(m map[perm]bool) []perm {
+	perms := make([]perm, 0, len(m))
+	for p := range m {
+		perms = append(perms, p)
+	}
+	sort.Slice(perms, func(i, j int) bool {
+		if perms[i].key != perms[j].key {
+			return perms[i].key < perms[j].key
+		}
+		return perms[i].rangeEnd < perms[j].rangeEnd
+	})
+	return perms
+}
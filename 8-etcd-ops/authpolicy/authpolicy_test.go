@@ -0,0 +1,152 @@
+package authpolicy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//changeStrings 返回变更的文本形式
+func changeStrings(changes []Change) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{name: "有效配置", doc: "roles:\n  - name: app\n    permissions:\n      - key: /app/\n        prefix: true\nusers:\n  - name: svc\n    roles: [app]\n"},
+		{name: "JSON格式", doc: `{"users": [{"name": "root", "roles": ["root"]}], "enable": true}`},
+		{name: "未知字段", doc: "role: []\n", err: "field role not found"},
+		{name: "重复角色", doc: "roles:\n  - name: app\n  - name: app\n", err: "duplicate role"},
+		{name: "权限类型错误", doc: "roles:\n  - name: app\n    permissions:\n      - key: /a\n        type: admin\n", err: "unknown permission type"},
+		{name: "prefix与range_end同时指定", doc: "roles:\n  - name: app\n    permissions:\n      - key: /a\n        prefix: true\n        range_end: /b\n", err: "exclusive"},
+		{name: "未声明的角色", doc: "users:\n  - name: svc\n    roles: [app]\n", err: "undeclared role"},
+		{name: "开启认证没有root用户", doc: "enable: true\n", err: "requires user root"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.doc))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: Parse = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPlanRole(t *testing.T) {
+	read := Permission{Key: "/app/", Prefix: true, Type: "read"}
+	write := Permission{Key: "/app/", Prefix: true, Type: "write"}
+	other := Permission{Key: "/other"}
+	tests := []struct {
+		name  string
+		have  []Permission //nil为角色不存在
+		want  []Permission
+		plans []string
+	}{
+		{name: "新建角色", want: []Permission{read}, plans: []string{`+ role app`, `+ permission app: read ["/app/", "/app0")`}},
+		{name: "没有变化", have: []Permission{read, other}, want: []Permission{other, read}},
+		{name: "修改权限类型", have: []Permission{read}, want: []Permission{write}, plans: []string{`~ permission app: write ["/app/", "/app0")`}},
+		{name: "撤销权限", have: []Permission{read, other}, want: []Permission{read}, plans: []string{`- permission app: readwrite "/other"`}},
+	}
+	for _, tt := range tests {
+		roles := make(map[string]map[perm]bool)
+		if tt.have != nil {
+			roles["app"] = make(map[perm]bool)
+			for _, p := range tt.have {
+				roles["app"][p.normalize()] = true
+			}
+		}
+		got := changeStrings(planRole(Role{Name: "app", Permissions: tt.want}, roles))
+		if !reflect.DeepEqual(got, tt.plans) {
+			t.Errorf("%s: planRole = %q, want %q", tt.name, got, tt.plans)
+		}
+	}
+}
+
+func TestUserPassword(t *testing.T) {
+	os.Setenv("AUTHPOLICY_TEST_PASSWORD", "s3cret")
+	defer os.Unsetenv("AUTHPOLICY_TEST_PASSWORD")
+	tests := []struct {
+		name     string
+		password string
+		want     string
+		err      bool
+	}{
+		{name: "明文", password: "plain", want: "plain"},
+		{name: "环境变量", password: "$AUTHPOLICY_TEST_PASSWORD", want: "s3cret"},
+		{name: "环境变量拼接", password: "${AUTHPOLICY_TEST_PASSWORD}-1", want: "s3cret-1"},
+		{name: "未设置的环境变量", password: "$AUTHPOLICY_TEST_UNSET", err: true},
+		{name: "空", password: ""},
+	}
+	for _, tt := range tests {
+		got, err := User{Name: "svc", Password: tt.password}.password()
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("%s: password = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	enabled, err := authEnabled(ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Skip("auth enabled, need a cluster without auth")
+	}
+	suffix := time.Now().UnixNano()
+	role, user := fmt.Sprintf("test-role-%d", suffix), fmt.Sprintf("test-user-%d", suffix)
+	defer cli.RoleDelete(ctx, role)
+	defer cli.UserDelete(ctx, user)
+	p := &Policy{
+		Roles: []Role{{Name: role, Permissions: []Permission{{Key: "/test/", Prefix: true, Type: "read"}}}},
+		Users: []User{{Name: user, Password: "pw", Roles: []string{role}}},
+	}
+	changes, err := p.Plan(ctx, cli, false)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want := []string{
+		"+ role " + role,
+		"+ permission " + role + `: read ["/test/", "/test0")`,
+		"+ user " + user,
+		"+ grant " + user + ": " + role,
+	}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Plan = %q, want %q", got, want)
+	}
+	if n, err := Apply(ctx, cli, changes); err != nil || n != len(changes) {
+		t.Fatalf("Apply = %d, %v", n, err)
+	}
+	//应用后再次Plan没有变更
+	if changes, err := p.Plan(ctx, cli, false); err != nil || len(changes) != 0 {
+		t.Fatalf("Plan after Apply = %q, %v, want no changes", changeStrings(changes), err)
+	}
+	p.Roles[0].Permissions[0].Type = "readwrite"
+	p.Users[0].Roles = nil
+	changes, err = p.Plan(ctx, cli, false)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want = []string{
+		"~ permission " + role + `: readwrite ["/test/", "/test0")`,
+		"- grant " + user + ": " + role,
+	}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Plan = %q, want %q", got, want)
+	}
+}
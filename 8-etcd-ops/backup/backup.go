@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+)
+
+const (
+	//filePrefix 备份文件名前缀，文件名为etcd-<时间>.db
+	filePrefix = "etcd-"
+	fileSuffix = ".db"
+	//metaSuffix 元数据文件后缀，与备份文件同名
+	metaSuffix = ".json"
+	//partSuffix 写入中的临时文件后缀
+	partSuffix = ".part"
+	//timeLayout 文件名中的时间格式，精确到毫秒，按文件名排序即按时间排序
+	timeLayout = "20060102-150405.000"
+)
+
+//Metadata 备份的元数据，与备份文件一起保存为etcd-<时间>.db.json
+type Metadata struct {
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"` //整个备份文件的sha256
+	ClusterID string    `json:"cluster_id"`
+	MemberID  string    `json:"member_id"` //提供快照的成员
+	Revision  int64     `json:"revision"`  //快照中的最大版本号
+	Keys      int       `json:"keys"`      //快照中未删除的key数
+	CreatedAt time.Time `json:"created_at"`
+	Duration  string    `json:"duration"`
+}
+
+//Options 备份选项
+type Options struct {
+	Dir      string //备份目录
+	Keep     int    //保留最近的备份数，0为不删除
+	Endpoint string //提供快照的成员的client地址，默认为client的第一个地址
+}
+
+//Backup 把Maintenance.Snapshot流式写入备份目录，写完后校验快照并保存元数据，然后删除多余的旧备份。
+//快照和元数据都先写入临时文件，元数据先改名，快照最后改名，目录中出现的备份都有完整的元数据。
+//快照和集群ID、成员ID从同一个连接获取，元数据记录的是实际提供快照的成员
+func Backup(ctx context.Context, cli *clientv3.Client, opts Options) (*Metadata, error) {
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, err
+	}
+	ep := opts.Endpoint
+	if ep == "" {
+		ep = cli.Endpoints()[0]
+	}
+	//只连接ep，不经过client的负载均衡
+	conn, err := cli.Dial(ep)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	mc := clientv3.NewMaintenanceFromMaintenanceClient(pb.NewMaintenanceClient(conn), cli)
+
+	start := time.Now()
+	resp, err := mc.Status(ctx, ep)
+	if err != nil {
+		return nil, err
+	}
+	name := filePrefix + start.UTC().Format(timeLayout) + fileSuffix
+	path := filepath.Join(opts.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup: %s already exists", name)
+	}
+	tmp := path + partSuffix
+	size, sum, err := save(ctx, mc, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	status, err := Verify(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("backup: verify %s: %v", name, err)
+	}
+
+	meta := &Metadata{
+		File:      name,
+		Size:      size,
+		SHA256:    sum,
+		ClusterID: fmt.Sprintf("%x", resp.Header.ClusterId),
+		MemberID:  fmt.Sprintf("%x", resp.Header.MemberId),
+		Revision:  status.Revision,
+		Keys:      status.Keys,
+		CreatedAt: start,
+		Duration:  time.Since(start).String(),
+	}
+	if err := writeMetadata(path, meta); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		os.Remove(path + metaSuffix)
+		return nil, err
+	}
+	if opts.Keep > 0 {
+		if err := Rotate(opts.Dir, opts.Keep); err != nil {
+			return meta, err
+		}
+	}
+	return meta, nil
+}
+
+//save 把快照写入path并同步到磁盘，返回大小和sha256。path已存在时返回错误，同时进行的备份不会互相覆盖
+func save(ctx context.Context, mc clientv3.Maintenance, path string) (int64, string, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	rc, err := mc.Snapshot(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), rc)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+//writeMetadata 把元数据写入临时文件并同步到磁盘，再改名为备份文件的元数据文件
+func writeMetadata(path string, meta *Metadata) error {
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + metaSuffix + partSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+metaSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+//List 返回目录中的备份文件名，从旧到新排序
+func List(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+//Rotate 只保留最近keep个备份，同时删除对应的元数据文件
+func Rotate(dir string, keep int) error {
+	names, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		path := filepath.Join(dir, names[0])
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+//ReadMetadata 读取备份文件的元数据
+func ReadMetadata(path string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(path + metaSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var meta Metadata
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+//VerifyBackup 按元数据文件校验备份的大小和sha256，再用Verify校验快照内容。没有元数据文件时只执行Verify
+func VerifyBackup(path string) (*Status, *Metadata, error) {
+	meta, err := ReadMetadata(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if meta != nil {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, meta, err
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, meta, err
+		}
+		if size != meta.Size || hex.EncodeToString(h.Sum(nil)) != meta.SHA256 {
+			return nil, meta, ErrChecksum
+		}
+	}
+	status, err := Verify(path)
+	return status, meta, err
+}
@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+
+	"etcd-example/internal/embedtest"
+)
+
+//event 写入快照的一个版本
+type event struct {
+	rev int64
+	key string
+	del bool
+}
+
+//writeSnapshot 以mvcc的格式把events写入bolt文件，trailer为true时像Maintenance.Snapshot一样在末尾追加sha256
+func writeSnapshot(t *testing.T, path string, events []event, trailer bool) {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(keyBucket))
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			rev := make([]byte, revBytesLen, revBytesLen+1)
+			binary.BigEndian.PutUint64(rev, uint64(ev.rev))
+			rev[8] = '_'
+			if ev.del {
+				rev = append(rev, markTombstone)
+			}
+			kv := mvccpb.KeyValue{Key: []byte(ev.key), ModRevision: ev.rev}
+			val, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(rev, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !trailer {
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	if err := ioutil.WriteFile(path, append(b, sum[:]...), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	events := []event{{rev: 2, key: "a"}, {rev: 3, key: "b"}, {rev: 4, key: "a"}, {rev: 5, key: "b", del: true}, {rev: 6, key: "c"}}
+	tests := []struct {
+		name    string
+		trailer bool
+	}{
+		{name: "没有sha256", trailer: false},
+		{name: "末尾带有sha256", trailer: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%v.db", tt.trailer))
+		writeSnapshot(t, path, events, tt.trailer)
+		st, err := Verify(path)
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", tt.name, err)
+		}
+		if st.Revision != 6 || st.Keys != 2 || st.Versions != 5 || st.TotalKey != 5 || st.HasHash != tt.trailer {
+			t.Errorf("%s: Verify = %+v, want revision 6, 2 keys, 5 versions", tt.name, st)
+		}
+	}
+
+	//修改末尾的sha256
+	path := filepath.Join(dir, "true.db")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err != ErrChecksum {
+		t.Fatalf("Verify corrupted = %v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "etcd-20200601-030000.000.db")
+	writeSnapshot(t, path, []event{{rev: 2, key: "a"}}, true)
+
+	//没有元数据文件时只校验快照
+	if _, meta, err := VerifyBackup(path); err != nil || meta != nil {
+		t.Fatalf("VerifyBackup without metadata = %v, %v", meta, err)
+	}
+	meta := &Metadata{File: filepath.Base(path), Size: 1, SHA256: "00"}
+	if err := writeMetadata(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := VerifyBackup(path); err != ErrChecksum {
+		t.Fatalf("VerifyBackup with wrong metadata = %v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	names := []string{
+		"etcd-20200601-030000.000.db",
+		"etcd-20200601-030000.001.db",
+		"etcd-20200602-030000.000.db",
+		"etcd-20200603-030000.000.db",
+	}
+	for _, name := range names {
+		for _, file := range []string{name, name + metaSuffix} {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//写入中的临时文件和其他文件不参与轮转
+	others := []string{"etcd-20200604-030000.000.db" + partSuffix, "other.db"}
+	for _, file := range others {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Rotate(dir, 2); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	got, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, names[2:]) {
+		t.Fatalf("List after Rotate = %v, want %v", got, names[2:])
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, info := range infos {
+		files = append(files, info.Name())
+	}
+	want := []string{names[2], names[2] + metaSuffix, names[3], names[3] + metaSuffix, others[0], others[1]}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("files after Rotate = %v, want %v", files, want)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	//使用进程内的etcd，CI中没有外部etcd时也对真实快照执行Backup、Verify和Rotate
+	srv := embedtest.Start(t)
+	defer srv.Stop()
+	cli := srv.Client
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rev, err := srv.Put(ctx, "/test/"+t.Name(), "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metas []*Metadata
+	for i := 0; i < 3; i++ {
+		meta, err := Backup(ctx, cli, Options{Dir: dir, Keep: 2})
+		if err != nil {
+			t.Fatalf("Backup: %v", err)
+		}
+		metas = append(metas, meta)
+	}
+	meta := metas[2]
+	if meta.Revision < rev || meta.Keys != 1 {
+		t.Fatalf("Backup = %+v, want revision >= %d and 1 key", meta, rev)
+	}
+	status, err := cli.Status(ctx, cli.Endpoints()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MemberID != fmt.Sprintf("%x", status.Header.MemberId) || meta.ClusterID != fmt.Sprintf("%x", status.Header.ClusterId) {
+		t.Fatalf("Backup member %s cluster %s, want %x %x", meta.MemberID, meta.ClusterID, status.Header.MemberId, status.Header.ClusterId)
+	}
+
+	st, vmeta, err := VerifyBackup(filepath.Join(dir, meta.File))
+	if err != nil {
+		t.Fatalf("VerifyBackup: %v", err)
+	}
+	if !st.HasHash || st.Revision != meta.Revision || st.Keys != meta.Keys || vmeta.SHA256 != meta.SHA256 {
+		t.Fatalf("VerifyBackup = %+v, %+v, want %+v", st, vmeta, meta)
+	}
+	//只保留最近2个备份
+	names, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{metas[1].File, metas[2].File}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("List = %v, want %v", names, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, metas[0].File+metaSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("metadata of rotated backup: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+const (
+	//keyBucket mvcc存储键值的bucket，key为版本号
+	keyBucket = "key"
+	//revBytesLen 版本号编码的长度：8字节main、'_'、8字节sub，删除标记再追加't'
+	revBytesLen = 8 + 1 + 8
+	//markTombstone 删除标记
+	markTombstone = 't'
+)
+
+//ErrChecksum 快照内容与校验和不一致
+var ErrChecksum = errors.New("backup: checksum mismatch")
+
+//Status 快照的校验结果
+type Status struct {
+	Hash      uint32 `json:"hash"`      //与etcdctl snapshot status一致的crc32
+	Revision  int64  `json:"revision"`  //最大版本号
+	TotalKey  int    `json:"total_key"` //所有bucket的条目数，与etcdctl一致
+	TotalSize int64  `json:"total_size"`
+	Keys      int    `json:"keys"`     //最新版本中未删除的key数
+	Versions  int    `json:"versions"` //压缩后仍保留的历史版本数
+	HasHash   bool   `json:"has_hash"` //快照末尾是否带有sha256，Maintenance.Snapshot得到的快照带有
+}
+
+//Verify 打开快照，校验末尾的sha256和bolt文件结构，统计版本号和key数
+func Verify(path string) (*Status, error) {
+	st := &Status{}
+	hasHash, err := checkTrailer(path)
+	if err != nil {
+		return nil, err
+	}
+	st.HasHash = hasHash
+
+	db, err := bolt.Open(path, 0400, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	err = db.View(func(tx *bolt.Tx) error {
+		var errs []string
+		for err := range tx.Check() {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("backup: integrity check failed: %s", strings.Join(errs, "; "))
+		}
+		st.TotalSize = tx.Size()
+		live := make(map[string]bool)
+		c := tx.Cursor()
+		for name, _ := c.First(); name != nil; name, _ = c.Next() {
+			b := tx.Bucket(name)
+			if b == nil {
+				return fmt.Errorf("backup: cannot open bucket %s", name)
+			}
+			h.Write(name)
+			isKey := string(name) == keyBucket
+			err := b.ForEach(func(k, v []byte) error {
+				h.Write(k)
+				h.Write(v)
+				st.TotalKey++
+				if isKey {
+					return countKey(st, live, k, v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		st.Keys = len(live)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	st.Hash = h.Sum32()
+	return st, nil
+}
+
+//countKey 按版本号顺序回放key bucket，统计最新版本中未删除的key
+func countKey(st *Status, live map[string]bool, rev, val []byte) error {
+	if len(rev) < revBytesLen {
+		return fmt.Errorf("backup: invalid revision %x", rev)
+	}
+	st.Revision = int64(binary.BigEndian.Uint64(rev[:8]))
+	st.Versions++
+	var kv mvccpb.KeyValue
+	if err := kv.Unmarshal(val); err != nil {
+		return err
+	}
+	if len(rev) > revBytesLen && rev[revBytesLen] == markTombstone {
+		delete(live, string(kv.Key))
+	} else {
+		live[string(kv.Key)] = true
+	}
+	return nil
+}
+
+//checkTrailer 校验快照末尾的sha256。Maintenance.Snapshot在bolt文件(大小为512的倍数)后追加sha256，
+//没有追加时返回false
+func checkTrailer(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+	if size%512 != sha256.Size {
+		return false, nil
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size-sha256.Size); err != nil {
+		return false, err
+	}
+	trailer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return false, err
+	}
+	if !bytes.Equal(h.Sum(nil), trailer) {
+		return true, ErrChecksum
+	}
+	return true, nil
+}
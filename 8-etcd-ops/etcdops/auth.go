@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"etcd-example/8-etcd-ops/authpolicy"
+)
+
+func init() {
+	commands["auth"] = command{usage: "按声明式配置文件同步用户、角色和权限", run: runAuth}
+}
+
+func runAuth(args []string) error {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	file := fs.String("f", "", "YAML或JSON格式的认证配置文件")
+	apply := fs.Bool("apply", false, "执行变更，默认只输出计划")
+	prune := fs.Bool("prune", false, "删除配置中没有声明的用户和角色(root除外)")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("auth: -f is required")
+	}
+	policy, err := authpolicy.Load(*file)
+	if err != nil {
+		return err
+	}
+
+	cli := newClient()
+	defer cli.Close()
+	ctx := context.Background()
+	changes, err := policy.Plan(ctx, cli, *prune)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return nil
+	}
+	if !*apply {
+		fmt.Printf("%d changes, run with -apply to execute\n", len(changes))
+		return nil
+	}
+	n, err := authpolicy.Apply(ctx, cli, changes)
+	fmt.Printf("applied %d/%d changes\n", n, len(changes))
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"etcd-example/8-etcd-ops/backup"
+)
+
+func init() {
+	commands["backup"] = command{usage: "保存快照到备份目录并删除旧备份", run: runBackup}
+	commands["verify"] = command{usage: "校验快照文件，输出版本号和key数", run: runVerify}
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", "", "备份目录")
+	keep := fs.Int("keep", 7, "保留最近的备份数，0为不删除")
+	endpoint := fs.String("endpoint", "", "提供快照的成员地址，默认为-endpoints中的第一个")
+	asJSON := fs.Bool("json", false, "以JSON格式输出元数据")
+	fs.Parse(args)
+	if *dir == "" {
+		return errors.New("backup: -dir is required")
+	}
+
+	cli := newClient()
+	defer cli.Close()
+	meta, err := backup.Backup(context.Background(), cli, backup.Options{Dir: *dir, Keep: *keep, Endpoint: *endpoint})
+	if meta != nil {
+		if *asJSON {
+			printJSON(meta)
+		} else {
+			fmt.Printf("saved %s (%s) revision %d, %d keys, sha256 %s in %s\n",
+				meta.File, humanSize(meta.Size), meta.Revision, meta.Keys, meta.SHA256, meta.Duration)
+		}
+	}
+	return err
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	file := fs.String("f", "", "快照文件")
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("verify: -f is required")
+	}
+
+	status, meta, err := backup.VerifyBackup(*file)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(status)
+	}
+	fmt.Printf("revision %d, %d keys, %d versions, total key %d, size %s, hash %x\n",
+		status.Revision, status.Keys, status.Versions, status.TotalKey, humanSize(status.TotalSize), status.Hash)
+	if meta != nil {
+		fmt.Printf("sha256 matches %s, created at %s from member %s\n", meta.File, meta.CreatedAt.Format("2006-01-02 15:04:05"), meta.MemberID)
+	}
+	if !status.HasHash {
+		fmt.Println("no snapshot hash appended, file was not saved from Maintenance.Snapshot")
+	}
+	return nil
+}
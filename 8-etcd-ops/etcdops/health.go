@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"etcd-example/8-etcd-ops/health"
+)
+
+func init() {
+	commands["health"] = command{usage: "检查集群健康状态，或以HTTP提供JSON和Prometheus指标", run: runHealth}
+}
+
+func runHealth(args []string) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	listen := fs.String("listen", "", "HTTP监听地址，为空时检查一次并输出JSON")
+	fs.Parse(args)
+
+	cli := newClient()
+	defer cli.Close()
+	checker := health.NewChecker(cli, health.WithTimeout(*dialTimeout))
+	if *listen == "" {
+		return printJSON(checker.Check(context.Background()))
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(checker)
+	mux := http.NewServeMux()
+	mux.Handle("/health", checker)
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	log.Printf("health listening on %s", *listen)
+	return http.ListenAndServe(*listen, mux)
+}
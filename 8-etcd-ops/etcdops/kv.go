@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"etcd-example/8-etcd-ops/kvio"
+)
+
+func init() {
+	commands["export"] = command{usage: "导出前缀下的key到JSON/NDJSON文件", run: runExport}
+	commands["import"] = command{usage: "从导出文件导入key", run: runImport}
+}
+
+func parseFormat(s string) (kvio.Format, error) {
+	switch s {
+	case "json":
+		return kvio.JSON, nil
+	case "ndjson":
+		return kvio.NDJSON, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "导出的前缀")
+	out := fs.String("o", "-", "输出文件，-为标准输出")
+	format := fs.String("format", "ndjson", "文件格式 json|ndjson")
+	leases := fs.Bool("leases", false, "导出租约ID和剩余时间")
+	fs.Parse(args)
+	if *prefix == "" {
+		return errors.New("export: -prefix is required")
+	}
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	cli := newClient()
+	defer cli.Close()
+	hdr, err := kvio.Export(context.Background(), cli, *prefix, w, kvio.ExportOptions{Format: f, WithLease: *leases})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d keys under %s at revision %d\n", hdr.Count, hdr.Prefix, hdr.Revision)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("i", "-", "导入文件，-为标准输入")
+	format := fs.String("format", "ndjson", "文件格式 json|ndjson")
+	policy := fs.String("policy", "skip", "key已存在时的策略 skip|overwrite")
+	dryRun := fs.Bool("dry-run", false, "只统计，不写入")
+	leases := fs.Bool("leases", false, "为带TTL的key创建新租约")
+	batch := fs.Int("batch", kvio.DefaultBatchSize, "每个事务的操作数，不能超过etcd的--max-txn-ops")
+	fs.Parse(args)
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+	opts := kvio.ImportOptions{Format: f, DryRun: *dryRun, WithLease: *leases, BatchSize: *batch}
+	switch *policy {
+	case "skip":
+		opts.Policy = kvio.SkipExisting
+	case "overwrite":
+		opts.Policy = kvio.Overwrite
+	default:
+		return fmt.Errorf("unknown policy %q", *policy)
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	cli := newClient()
+	defer cli.Close()
+	stats, err := kvio.Import(context.Background(), cli, r, opts)
+	if stats != nil {
+		prefix := ""
+		if *dryRun {
+			prefix = "[dry-run] "
+		}
+		fmt.Fprintf(os.Stderr, "%stotal:%d duplicates:%d created:%d updated:%d skipped:%d batches:%d\n",
+			prefix, stats.Total, stats.Duplicates, stats.Created, stats.Updated, stats.Skipped, stats.Batches)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+//command 子命令
+type command struct {
+	usage string                    //用法说明
+	run   func(args []string) error //执行子命令，args不含子命令名
+}
+
+//commands 所有子命令，在各子命令文件的init中注册
+var commands = map[string]command{}
+
+var (
+	endpoints   = flag.String("endpoints", "localhost:2379", "etcd地址，多个以逗号分隔")
+	dialTimeout = flag.Duration("dial-timeout", 5*time.Second, "连接etcd的超时时间")
+	user        = flag.String("user", "", "开启认证时使用的用户名:密码")
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: etcdops [flags] <command> [command flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+//newClient 新建etcd client
+func newClient() *clientv3.Client {
+	cli, err := dial(*endpoints)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cli
+}
+
+//dial 以全局的-dial-timeout和-user连接eps，多个地址以逗号分隔
+func dial(eps string) (*clientv3.Client, error) {
+	cfg := clientv3.Config{
+		Endpoints:   strings.Split(eps, ","),
+		DialTimeout: *dialTimeout,
+	}
+	if *user != "" {
+		parts := strings.SplitN(*user, ":", 2)
+		cfg.Username = parts[0]
+		if len(parts) == 2 {
+			cfg.Password = parts[1]
+		}
+	}
+	return clientv3.New(cfg)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"etcd-example/8-etcd-ops/maintenance"
+)
+
+func init() {
+	commands["maintain"] = command{usage: "定期压缩和逐个成员整理碎片", run: runMaintain}
+}
+
+func runMaintain(args []string) error {
+	fs := flag.NewFlagSet("maintain", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Hour, "执行间隔")
+	retention := fs.Int64("retention", 10000, "压缩时保留的版本数，0为不压缩")
+	threshold := fs.Int64("defrag-threshold", 100*1024*1024, "碎片整理的DB大小阈值(字节)，负数为不整理")
+	once := fs.Bool("once", false, "只执行一次")
+	asJSON := fs.Bool("json", false, "以JSON格式输出结果")
+	fs.Parse(args)
+
+	cli := newClient()
+	defer cli.Close()
+	s := maintenance.New(cli,
+		maintenance.WithInterval(*interval),
+		maintenance.WithRetention(*retention),
+		maintenance.WithDefragThreshold(*threshold),
+		maintenance.WithTimeout(*dialTimeout),
+	)
+	report := func(r *maintenance.Report) {
+		if *asJSON {
+			printJSON(r)
+			return
+		}
+		printReport(r)
+	}
+	if *once {
+		r, err := s.RunOnce(context.Background())
+		report(r)
+		return err
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	if err := s.Run(ctx, report); err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+func printReport(r *maintenance.Report) {
+	if r.CompactedTo > 0 {
+		fmt.Printf("revision %d, compacted to %d\n", r.Revision, r.CompactedTo)
+	} else {
+		fmt.Printf("revision %d, no compaction\n", r.Revision)
+	}
+	for _, d := range r.Defrags {
+		if d.Skipped != "" {
+			fmt.Printf("  %s(%s) leader=%v db %s, skipped: %s\n", d.Name, d.Endpoint, d.Leader, humanSize(d.Before), d.Skipped)
+			continue
+		}
+		fmt.Printf("  %s(%s) leader=%v db %s -> %s in %v\n", d.Name, d.Endpoint, d.Leader,
+			humanSize(d.Before), humanSize(d.After), d.Duration.Round(time.Millisecond))
+	}
+}
+
+//humanSize 以KB/MB/GB显示字节数
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"etcd-example/8-etcd-ops/member"
+)
+
+func init() {
+	commands["member"] = command{usage: "成员管理 list|add|remove|update", run: runMember}
+}
+
+func runMember(args []string) error {
+	if len(args) == 0 {
+		return errors.New("member: list|add|remove|update required")
+	}
+	fs := flag.NewFlagSet("member "+args[0], flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	yes := fs.Bool("yes", false, "不提示确认")
+	var force *bool
+	if args[0] == "add" {
+		force = fs.Bool("force", false, "成员数为奇数时仍然添加，新成员启动前集群的容错能力降低")
+	}
+	var name, peerURLs *string
+	if args[0] != "list" {
+		name = fs.String("name", "", "成员名称，未启动的成员使用十六进制ID")
+	}
+	if args[0] == "add" || args[0] == "update" {
+		peerURLs = fs.String("peer-urls", "", "peer地址，多个以逗号分隔")
+	}
+	fs.Parse(args[1:])
+	if name != nil && *name == "" {
+		return errors.New("member: -name is required")
+	}
+	if peerURLs != nil && *peerURLs == "" {
+		return errors.New("member: -peer-urls is required")
+	}
+
+	cli := newClient()
+	defer cli.Close()
+	ctx := context.Background()
+	members, err := member.List(ctx, cli, *dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if *asJSON {
+			return printJSON(members)
+		}
+		printMembers(members)
+		return nil
+	case "add":
+		if err := member.CheckAdd(members, *force); err != nil {
+			return err
+		}
+		if !*yes && !confirm(fmt.Sprintf("add member %s with peer urls %s?", *name, *peerURLs)) {
+			return nil
+		}
+		r, err := member.Add(ctx, cli, members, *name, strings.Split(*peerURLs, ","), *force)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(r)
+		}
+		fmt.Printf("added member %s(%s), start it with:\n", r.Member.Name, r.Member.ID)
+		for _, f := range r.Flags() {
+			fmt.Println("  " + f)
+		}
+		return nil
+	case "remove":
+		m, err := member.CheckRemove(members, *name)
+		if err != nil {
+			return err
+		}
+		prompt := fmt.Sprintf("remove member %s(%s)?", m.Name, m.ID)
+		if m.Leader {
+			to := member.Transferee(members, m)
+			prompt = fmt.Sprintf("remove leader %s(%s) after moving leadership to %s(%s)?", m.Name, m.ID, to.Name, to.ID)
+		}
+		if !*yes && !confirm(prompt) {
+			return nil
+		}
+		if _, err := member.Remove(ctx, cli, members, *name); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(m)
+		}
+		fmt.Printf("removed member %s(%s)\n", m.Name, m.ID)
+		return nil
+	case "update":
+		if !*yes && !confirm(fmt.Sprintf("update member %s peer urls to %s?", *name, *peerURLs)) {
+			return nil
+		}
+		m, err := member.Update(ctx, cli, members, *name, strings.Split(*peerURLs, ","))
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(m)
+		}
+		fmt.Printf("updated member %s(%s) peer urls to %s\n", m.Name, m.ID, strings.Join(m.PeerURLs, ","))
+		return nil
+	}
+	return fmt.Errorf("member: unknown command %q", args[0])
+}
+
+func printMembers(members []*member.Member) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPEER URLS\tCLIENT URLS\tHEALTHY\tLEADER\tVERSION\tDB SIZE\tRAFT INDEX\tERROR")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%v\t%s\t%d\t%d\t%s\n", m.ID, m.Name,
+			strings.Join(m.PeerURLs, ","), strings.Join(m.ClientURLs, ","),
+			m.Healthy, m.Leader, m.Version, m.DBSize, m.RaftIndex, m.Error)
+	}
+	w.Flush()
+}
+
+//printJSON 以缩进的JSON格式输出到标准输出
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+//confirm 在标准错误输出提示，标准输入为y或yes时返回true
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Fprintln(os.Stderr, "aborted")
+	return false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+
+	"etcd-example/8-etcd-ops/mirror"
+)
+
+func init() {
+	commands["mirror"] = command{usage: "把前缀持续复制到另一个etcd集群", run: runMirror}
+}
+
+func runMirror(args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	dstEndpoints := fs.String("dest-endpoints", "", "目标集群地址，多个以逗号分隔")
+	prefix := fs.String("prefix", "", "复制的源前缀")
+	destPrefix := fs.String("dest-prefix", "", "目标前缀，默认与源前缀相同")
+	checkpointKey := fs.String("checkpoint-key", "", "目标集群中保存已应用版本号的key，默认为/__mirror加上源前缀")
+	fs.Parse(args)
+	if *dstEndpoints == "" || *prefix == "" {
+		return errors.New("mirror: -dest-endpoints and -prefix are required")
+	}
+
+	src := newClient()
+	defer src.Close()
+	//目标集群使用相同的-user认证
+	dst, err := dial(*dstEndpoints)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	var opts []mirror.Option
+	if *destPrefix != "" {
+		opts = append(opts, mirror.WithDestPrefix(*destPrefix))
+	}
+	if *checkpointKey != "" {
+		opts = append(opts, mirror.WithCheckpointKey(*checkpointKey))
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	err = mirror.New(src, dst, *prefix, opts...).Run(ctx)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+//signalContext 返回收到中断信号时取消的ctx
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		select {
+		case <-sigc:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigc)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"etcd-example/8-etcd-ops/member"
+)
+
+//defaultTimeout 默认的检查超时
+const defaultTimeout = 5 * time.Second
+
+//Member 成员的健康状态
+type Member struct {
+	*member.Member
+	RaftIndexLag uint64 `json:"raft_index_lag"` //与leader的raft index之差
+}
+
+//Alarm 集群中的告警
+type Alarm struct {
+	MemberID string `json:"member_id"`
+	Member   string `json:"member"` //成员名称，未启动或已移除的成员为ID
+	Alarm    string `json:"alarm"`  //NOSPACE|CORRUPT
+}
+
+//Report 集群的健康状态
+type Report struct {
+	Healthy   bool      `json:"healthy"` //所有成员健康、有leader且没有告警
+	Leader    string    `json:"leader"`  //leader名称，没有leader时为空
+	Members   []*Member `json:"members"`
+	Alarms    []Alarm   `json:"alarms"`
+	Errors    []string  `json:"errors,omitempty"` //获取成员列表或告警失败的原因
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+//Option 健康检查的可选配置
+type Option func(*Checker)
+
+//WithTimeout 设置一次检查的超时，默认5秒
+func WithTimeout(d time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = d
+	}
+}
+
+//Checker 通过MemberList、Maintenance.Status和AlarmList检查集群健康状态。
+//实现了http.Handler输出JSON，和prometheus.Collector在每次采集时检查
+type Checker struct {
+	cli     *clientv3.Client
+	timeout time.Duration
+}
+
+//NewChecker 新建健康检查
+func NewChecker(cli *clientv3.Client, opts ...Option) *Checker {
+	c := &Checker{cli: cli, timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+//Check 检查一次集群健康状态，失败的部分记录在Report.Errors中
+func (c *Checker) Check(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	r := &Report{CheckedAt: time.Now(), Members: []*Member{}, Alarms: []Alarm{}}
+	members, err := member.List(ctx, c.cli, c.timeout)
+	if err != nil {
+		r.Errors = append(r.Errors, "member list: "+err.Error())
+		return r
+	}
+	names := make(map[string]string, len(members))
+	var leaderIndex uint64
+	r.Healthy = true
+	for _, m := range members {
+		names[m.ID] = m.Name
+		if m.Leader {
+			r.Leader = m.Name
+			leaderIndex = m.RaftIndex
+		}
+		if !m.Healthy {
+			r.Healthy = false
+		}
+	}
+	for _, m := range members {
+		hm := &Member{Member: m}
+		if m.Healthy && leaderIndex > m.RaftIndex {
+			hm.RaftIndexLag = leaderIndex - m.RaftIndex
+		}
+		r.Members = append(r.Members, hm)
+	}
+	if r.Leader == "" {
+		r.Healthy = false
+	}
+
+	resp, err := c.cli.AlarmList(ctx)
+	if err != nil {
+		r.Errors = append(r.Errors, "alarm list: "+err.Error())
+		r.Healthy = false
+		return r
+	}
+	for _, a := range resp.Alarms {
+		id := formatID(a.MemberID)
+		name := names[id]
+		if name == "" {
+			name = id
+		}
+		r.Alarms = append(r.Alarms, Alarm{MemberID: id, Member: name, Alarm: a.Alarm.String()})
+		r.Healthy = false
+	}
+	return r
+}
+
+//ServeHTTP 输出JSON格式的健康状态，不健康时状态码为503
+func (c *Checker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r := c.Check(req.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !r.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(r)
+}
+
+//formatID 以十六进制格式化成员ID，与member.Member.ID一致
+func formatID(id uint64) string {
+	return strconv.FormatUint(id, 16)
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestCheck(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	r := NewChecker(cli).Check(context.Background())
+	if !r.Healthy || r.Leader == "" || len(r.Errors) > 0 {
+		t.Fatalf("Check = %+v, want healthy with leader", r)
+	}
+	for _, m := range r.Members {
+		if !m.Healthy || m.Version == "" || m.DBSize == 0 {
+			t.Errorf("member %s = %+v, want healthy with status", m.Name, m.Member)
+		}
+	}
+	//每个健康成员6个指标，加上集群的healthy
+	if n, want := testutil.CollectAndCount(NewChecker(cli)), 1+6*len(r.Members); n != want {
+		t.Errorf("collected %d metrics, want %d", n, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	w := httptest.NewRecorder()
+	NewChecker(cli).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var r Report
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Healthy || r.Leader == "" || len(r.Members) == 0 {
+		t.Fatalf("report = %+v, want healthy with members", r)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	//没有etcd监听的地址，获取成员列表超时
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"127.0.0.1:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	c := NewChecker(cli, WithTimeout(200*time.Millisecond))
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var r Report
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Healthy || len(r.Errors) != 1 || !strings.HasPrefix(r.Errors[0], "member list: ") {
+		t.Fatalf("report = %+v, want member list error", r)
+	}
+	expected := `
+# HELP etcd_health_healthy Whether all members are healthy, a leader exists and no alarm is active.
+# TYPE etcd_health_healthy gauge
+etcd_health_healthy 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
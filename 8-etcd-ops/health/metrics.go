@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	healthyDesc = prometheus.NewDesc("etcd_health_healthy",
+		"Whether all members are healthy, a leader exists and no alarm is active.", nil, nil)
+	upDesc = prometheus.NewDesc("etcd_health_member_up",
+		"Whether the member responded to the status request.", []string{"member"}, nil)
+	leaderDesc = prometheus.NewDesc("etcd_health_member_is_leader",
+		"Whether the member is the leader.", []string{"member"}, nil)
+	infoDesc = prometheus.NewDesc("etcd_health_member_info",
+		"Member version, always 1.", []string{"member", "id", "version"}, nil)
+	dbSizeDesc = prometheus.NewDesc("etcd_health_member_db_size_bytes",
+		"Size of the member backend database.", []string{"member"}, nil)
+	raftIndexDesc = prometheus.NewDesc("etcd_health_member_raft_index",
+		"Raft index of the member.", []string{"member"}, nil)
+	raftLagDesc = prometheus.NewDesc("etcd_health_member_raft_index_lag",
+		"Raft index difference between the leader and the member.", []string{"member"}, nil)
+	alarmDesc = prometheus.NewDesc("etcd_health_alarm",
+		"Active alarm, always 1.", []string{"member", "alarm"}, nil)
+)
+
+//Describe 实现prometheus.Collector
+func (c *Checker) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{healthyDesc, upDesc, leaderDesc, infoDesc, dbSizeDesc, raftIndexDesc, raftLagDesc, alarmDesc} {
+		ch <- d
+	}
+}
+
+//Collect 实现prometheus.Collector，每次采集时检查一次
+func (c *Checker) Collect(ch chan<- prometheus.Metric) {
+	r := c.Check(context.Background())
+	ch <- prometheus.MustNewConstMetric(healthyDesc, prometheus.GaugeValue, boolValue(r.Healthy))
+	for _, m := range r.Members {
+		name := m.Name
+		if name == "" {
+			//未启动的成员没有名称
+			name = m.ID
+		}
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, boolValue(m.Healthy), name)
+		if !m.Healthy {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, boolValue(m.Leader), name)
+		ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1, name, m.ID, m.Version)
+		ch <- prometheus.MustNewConstMetric(dbSizeDesc, prometheus.GaugeValue, float64(m.DBSize), name)
+		ch <- prometheus.MustNewConstMetric(raftIndexDesc, prometheus.GaugeValue, float64(m.RaftIndex), name)
+		ch <- prometheus.MustNewConstMetric(raftLagDesc, prometheus.GaugeValue, float64(m.RaftIndexLag), name)
+	}
+	for _, a := range r.Alarms {
+		ch <- prometheus.MustNewConstMetric(alarmDesc, prometheus.GaugeValue, 1, a.Member, a.Alarm)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,393 @@
+package kvio
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+//Format 导出文件格式
+type Format int
+
+const (
+	//JSON 整个文件为一个JSON文档
+	JSON Format = iota
+	//NDJSON 每行一个JSON对象，第一行为Header
+	NDJSON
+)
+
+//Policy 导入时key已存在的处理策略
+type Policy int
+
+const (
+	//Overwrite 覆盖已存在的key
+	Overwrite Policy = iota
+	//SkipExisting 跳过已存在的key
+	SkipExisting
+)
+
+//DefaultBatchSize 默认每个事务的操作数，等于etcd默认的--max-txn-ops
+const DefaultBatchSize = 128
+
+//base64Encoding key或value不是合法UTF-8时使用base64编码
+const base64Encoding = "base64"
+
+//Header 导出文件的元数据
+type Header struct {
+	Prefix     string    `json:"prefix"`
+	Revision   int64     `json:"revision"`   //导出时的版本号
+	ClusterID  uint64    `json:"cluster_id"` //导出的集群ID
+	ExportedAt time.Time `json:"exported_at"`
+	Count      int       `json:"count"`
+}
+
+//KV 导出的键值对
+type KV struct {
+	Key            string `json:"key"`
+	Value          string `json:"value"`
+	Encoding       string `json:"encoding,omitempty"` //为base64时key和value经过base64编码
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
+	Lease          int64  `json:"lease,omitempty"` //导出时的租约ID
+	TTL            int64  `json:"ttl,omitempty"`   //导出时租约的剩余时间(秒)
+}
+
+//document JSON格式的导出文件
+type document struct {
+	Header Header `json:"header"`
+	KVs    []KV   `json:"kvs"`
+}
+
+//ExportOptions 导出选项
+type ExportOptions struct {
+	Format    Format
+	WithLease bool  //导出租约ID和剩余时间
+	PageSize  int64 //分页读取的大小，0为DefaultBatchSize
+}
+
+//Export 把前缀pfx下的所有key导出到w，分页读取同一版本号保证一致性，每读取一页写入一页
+func Export(ctx context.Context, cli *clientv3.Client, pfx string, w io.Writer, opts ExportOptions) (*Header, error) {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultBatchSize
+	}
+	end := clientv3.GetPrefixRangeEnd(pfx)
+	//先读取key数和版本号写入Header，之后的分页都读取该版本
+	resp, err := cli.Get(ctx, pfx, clientv3.WithRange(end), clientv3.WithCountOnly())
+	if err != nil {
+		return nil, err
+	}
+	hdr := &Header{
+		Prefix:     pfx,
+		Revision:   resp.Header.Revision,
+		ClusterID:  resp.Header.ClusterId,
+		ExportedAt: time.Now(),
+		Count:      int(resp.Count),
+	}
+	enc := newEncoder(w, opts.Format)
+	if err := enc.header(hdr); err != nil {
+		return nil, err
+	}
+	//导出时相同租约的key只查询一次剩余时间
+	ttls := make(map[int64]int64)
+	for from := pfx; ; {
+		resp, err := cli.Get(ctx, from, clientv3.WithRange(end), clientv3.WithLimit(pageSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithRev(hdr.Revision))
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range resp.Kvs {
+			e := newKV(string(kv.Key), kv.Value)
+			e.CreateRevision, e.ModRevision, e.Version = kv.CreateRevision, kv.ModRevision, kv.Version
+			if opts.WithLease && kv.Lease != 0 {
+				e.Lease = kv.Lease
+				ttl, ok := ttls[kv.Lease]
+				if !ok {
+					resp, err := cli.TimeToLive(ctx, clientv3.LeaseID(kv.Lease))
+					if err != nil {
+						return nil, err
+					}
+					ttl = resp.TTL
+					ttls[kv.Lease] = ttl
+				}
+				e.TTL = ttl
+			}
+			if err := enc.kv(e); err != nil {
+				return nil, err
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+	return hdr, enc.close()
+}
+
+//encoder 逐个写入导出文件的Header和键值对
+type encoder struct {
+	w      io.Writer
+	format Format
+	n      int //已写入的键值对数
+}
+
+func newEncoder(w io.Writer, format Format) *encoder {
+	return &encoder{w: w, format: format}
+}
+
+func (e *encoder) header(hdr *Header) error {
+	if e.format == NDJSON {
+		return json.NewEncoder(e.w).Encode(hdr)
+	}
+	b, err := json.MarshalIndent(hdr, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "{\n  \"header\": %s,\n  \"kvs\": [", b)
+	return err
+}
+
+func (e *encoder) kv(kv KV) error {
+	e.n++
+	if e.format == NDJSON {
+		return json.NewEncoder(e.w).Encode(kv)
+	}
+	b, err := json.MarshalIndent(kv, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n    "
+	if e.n == 1 {
+		sep = "\n    "
+	}
+	_, err = fmt.Fprintf(e.w, "%s%s", sep, b)
+	return err
+}
+
+//close 结束JSON文档
+func (e *encoder) close() error {
+	if e.format == NDJSON {
+		return nil
+	}
+	end := "\n  ]\n}\n"
+	if e.n == 0 {
+		end = "]\n}\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+//ImportOptions 导入选项
+type ImportOptions struct {
+	Format    Format
+	Policy    Policy
+	DryRun    bool //只统计，不写入
+	WithLease bool //为带TTL的key创建新租约
+	BatchSize int  //每个事务的操作数，0为DefaultBatchSize
+}
+
+//ImportStats 导入统计
+type ImportStats struct {
+	Total      int //文件中的key数
+	Duplicates int //文件中重复的key数，以最后一次出现的value为准
+	Created    int //新建的key数
+	Updated    int //覆盖的key数
+	Skipped    int //跳过的已存在key数
+	Batches    int //提交的事务数
+}
+
+//Import 从r读取导出文件并写入etcd，每BatchSize个key提交一个事务
+func Import(ctx context.Context, cli *clientv3.Client, r io.Reader, opts ImportOptions) (*ImportStats, error) {
+	_, kvs, err := Read(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	stats := &ImportStats{Total: len(kvs)}
+	//同一个事务中不能重复修改同一个key
+	if kvs, err = dedup(kvs); err != nil {
+		return nil, err
+	}
+	stats.Duplicates = stats.Total - len(kvs)
+	//导出时相同租约的key使用同一个新租约
+	leases := make(map[int64]clientv3.LeaseID)
+	for start := 0; start < len(kvs); start += batchSize {
+		end := start + batchSize
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+		if err := importBatch(ctx, cli, kvs[start:end], opts, leases, stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+//Read 读取导出文件
+func Read(r io.Reader, format Format) (*Header, []KV, error) {
+	if format == JSON {
+		var doc document
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, nil, err
+		}
+		return &doc.Header, doc.KVs, nil
+	}
+	var (
+		hdr Header
+		kvs []KV
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	//第一个非空行为Header，line为实际行号
+	hasHeader := false
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var err error
+		if !hasHeader {
+			err = json.Unmarshal(sc.Bytes(), &hdr)
+			hasHeader = true
+		} else {
+			var kv KV
+			err = json.Unmarshal(sc.Bytes(), &kv)
+			kvs = append(kvs, kv)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("kvio: line %d: %v", line, err)
+		}
+	}
+	return &hdr, kvs, sc.Err()
+}
+
+//dedup 去除重复的key，以最后一次出现的value为准，保留第一次出现的位置
+func dedup(kvs []KV) ([]KV, error) {
+	index := make(map[string]int, len(kvs))
+	out := make([]KV, 0, len(kvs))
+	for _, kv := range kvs {
+		key, _, err := kv.decode()
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[key]; ok {
+			out[i] = kv
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, kv)
+	}
+	return out, nil
+}
+
+//importBatch 在一个事务中导入一批key，SkipExisting时每个key使用嵌套事务判断是否存在
+func importBatch(ctx context.Context, cli *clientv3.Client, kvs []KV, opts ImportOptions, leases map[int64]clientv3.LeaseID, stats *ImportStats) error {
+	//先读取已存在的key用于统计和预演
+	gets := make([]clientv3.Op, 0, len(kvs))
+	for _, kv := range kvs {
+		key, _, err := kv.decode()
+		if err != nil {
+			return err
+		}
+		gets = append(gets, clientv3.OpGet(key, clientv3.WithCountOnly()))
+	}
+	resp, err := cli.Txn(ctx).Then(gets...).Commit()
+	if err != nil {
+		return err
+	}
+	ops := make([]clientv3.Op, 0, len(kvs))
+	for i, kv := range kvs {
+		key, val, _ := kv.decode()
+		exists := resp.Responses[i].GetResponseRange().Count > 0
+		switch {
+		case !exists:
+			stats.Created++
+		case opts.Policy == SkipExisting:
+			stats.Skipped++
+			continue
+		default:
+			stats.Updated++
+		}
+		if opts.DryRun {
+			continue
+		}
+		var putOpts []clientv3.OpOption
+		if opts.WithLease && kv.TTL > 0 {
+			id, err := grantLease(ctx, cli, kv, leases)
+			if err != nil {
+				return err
+			}
+			putOpts = append(putOpts, clientv3.WithLease(id))
+		}
+		put := clientv3.OpPut(key, val, putOpts...)
+		if opts.Policy == SkipExisting {
+			//读取之后才创建的key同样跳过
+			put = clientv3.OpTxn([]clientv3.Cmp{clientv3.Compare(clientv3.CreateRevision(key), "=", 0)}, []clientv3.Op{put}, nil)
+		}
+		ops = append(ops, put)
+	}
+	if opts.DryRun || len(ops) == 0 {
+		return nil
+	}
+	if _, err := cli.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return err
+	}
+	stats.Batches++
+	return nil
+}
+
+//grantLease 为导出时的租约创建TTL相同的新租约
+func grantLease(ctx context.Context, cli *clientv3.Client, kv KV, leases map[int64]clientv3.LeaseID) (clientv3.LeaseID, error) {
+	if id, ok := leases[kv.Lease]; ok {
+		return id, nil
+	}
+	resp, err := cli.Grant(ctx, kv.TTL)
+	if err != nil {
+		return 0, err
+	}
+	leases[kv.Lease] = resp.ID
+	return resp.ID, nil
+}
+
+//newKV 新建导出的键值对，key或value不是合法UTF-8时使用base64编码
+func newKV(key string, val []byte) KV {
+	if utf8.ValidString(key) && utf8.Valid(val) {
+		return KV{Key: key, Value: string(val)}
+	}
+	return KV{
+		Key:      base64.StdEncoding.EncodeToString([]byte(key)),
+		Value:    base64.StdEncoding.EncodeToString(val),
+		Encoding: base64Encoding,
+	}
+}
+
+//decode 返回解码后的key和value
+func (kv KV) decode() (string, string, error) {
+	switch kv.Encoding {
+	case "":
+		return kv.Key, kv.Value, nil
+	case base64Encoding:
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return "", "", err
+		}
+		val, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return "", "", err
+		}
+		return string(key), string(val), nil
+	default:
+		return "", "", errors.New("kvio: unknown encoding " + kv.Encoding)
+	}
+}
@@ -0,0 +1,251 @@
+package kvio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestNewKV(t *testing.T) {
+	tests := []struct {
+		key      string
+		val      []byte
+		encoding string
+	}{
+		{key: "/app/name", val: []byte("etcd"), encoding: ""},
+		{key: "/app/中文", val: []byte("值"), encoding: ""},
+		{key: "/app/empty", val: nil, encoding: ""},
+		//key或value不是合法UTF-8时使用base64编码
+		{key: "/app/bin", val: []byte{0xff, 0x00, 0xfe}, encoding: base64Encoding},
+		{key: "/app/\xff", val: []byte("text"), encoding: base64Encoding},
+	}
+	for _, tt := range tests {
+		kv := newKV(tt.key, tt.val)
+		if kv.Encoding != tt.encoding {
+			t.Errorf("newKV(%q).Encoding = %q, want %q", tt.key, kv.Encoding, tt.encoding)
+		}
+		key, val, err := kv.decode()
+		if err != nil {
+			t.Fatalf("decode %+v: %v", kv, err)
+		}
+		if key != tt.key || val != string(tt.val) {
+			t.Errorf("decode(newKV(%q, %q)) = %q, %q", tt.key, tt.val, key, val)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		kv      KV
+		key     string
+		val     string
+		wantErr bool
+	}{
+		{kv: KV{Key: "a", Value: "b"}, key: "a", val: "b"},
+		{kv: KV{Key: "YQ==", Value: "Yg==", Encoding: base64Encoding}, key: "a", val: "b"},
+		{kv: KV{Key: "!!", Value: "Yg==", Encoding: base64Encoding}, wantErr: true},
+		{kv: KV{Key: "YQ==", Value: "!!", Encoding: base64Encoding}, wantErr: true},
+		{kv: KV{Key: "a", Value: "b", Encoding: "hex"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		key, val, err := tt.kv.decode()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decode(%+v) err = %v, wantErr %v", tt.kv, err, tt.wantErr)
+			continue
+		}
+		if key != tt.key || val != tt.val {
+			t.Errorf("decode(%+v) = %q, %q, want %q, %q", tt.kv, key, val, tt.key, tt.val)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		input   string
+		prefix  string
+		keys    []string
+		wantErr string
+	}{
+		{
+			name:   "NDJSON",
+			format: NDJSON,
+			input:  `{"prefix":"/app/","count":2}` + "\n" + `{"key":"/app/a","value":"1"}` + "\n" + `{"key":"/app/b","value":"2"}` + "\n",
+			prefix: "/app/",
+			keys:   []string{"/app/a", "/app/b"},
+		},
+		{
+			//开头的空行不能把Header当作键值对
+			name:   "NDJSON开头有空行",
+			format: NDJSON,
+			input:  "\n\n" + `{"prefix":"/app/","count":1}` + "\n\n" + `{"key":"/app/a","value":"1"}`,
+			prefix: "/app/",
+			keys:   []string{"/app/a"},
+		},
+		{
+			name:    "NDJSON错误行号",
+			format:  NDJSON,
+			input:   "\n" + `{"prefix":"/app/"}` + "\n" + `{"key":`,
+			wantErr: "kvio: line 3:",
+		},
+		{
+			name:   "JSON",
+			format: JSON,
+			input:  `{"header":{"prefix":"/app/","count":1},"kvs":[{"key":"/app/a","value":"1"}]}`,
+			prefix: "/app/",
+			keys:   []string{"/app/a"},
+		},
+		{
+			name:   "空文件",
+			format: NDJSON,
+			input:  "",
+		},
+	}
+	for _, tt := range tests {
+		hdr, kvs, err := Read(strings.NewReader(tt.input), tt.format)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %s...", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if hdr.Prefix != tt.prefix {
+			t.Errorf("%s: prefix = %q, want %q", tt.name, hdr.Prefix, tt.prefix)
+		}
+		var keys []string
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: keys = %q, want %q", tt.name, keys, tt.keys)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	kvs := []KV{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2"},
+		//与a相同的key，base64编码
+		{Key: "YQ==", Value: "Mw==", Encoding: base64Encoding},
+		{Key: "b", Value: "4"},
+	}
+	got, err := dedup(kvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KV{kvs[2], kvs[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dedup = %+v, want %+v", got, want)
+	}
+}
+
+func TestEncoderJSON(t *testing.T) {
+	hdr := &Header{Prefix: "/app/", Revision: 10, Count: 2, ExportedAt: time.Unix(1600000000, 0).UTC()}
+	for _, kvs := range [][]KV{
+		{},
+		{{Key: "/app/a", Value: "1", Version: 1}, {Key: "/app/b", Value: "2", Lease: 7, TTL: 30}},
+	} {
+		var buf bytes.Buffer
+		enc := newEncoder(&buf, JSON)
+		if err := enc.header(hdr); err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range kvs {
+			if err := enc.kv(kv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.close(); err != nil {
+			t.Fatal(err)
+		}
+		//逐个写入的结果与一次编码整个文档相同
+		want, _ := json.MarshalIndent(document{Header: *hdr, KVs: kvs}, "", "  ")
+		if got := buf.String(); got != string(want)+"\n" {
+			t.Errorf("streamed JSON =\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t) + "/"
+	lease, err := cli.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Revoke(ctx, lease.ID)
+	for i := 0; i < 5; i++ {
+		if _, err := cli.Put(ctx, fmt.Sprintf("%sk%d", pfx, i), fmt.Sprint(i), clientv3.WithLease(lease.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cli.Put(ctx, pfx+"bin", "\xff\xfe")
+
+	for _, format := range []Format{JSON, NDJSON} {
+		var buf bytes.Buffer
+		//分页小于key数，跨页读取同一版本
+		hdr, err := Export(ctx, cli, pfx, &buf, ExportOptions{Format: format, WithLease: true, PageSize: 2})
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		if hdr.Count != 6 {
+			t.Errorf("Count = %d, want 6", hdr.Count)
+		}
+		_, kvs, err := Read(bytes.NewReader(buf.Bytes()), format)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if len(kvs) != 6 {
+			t.Fatalf("read %d keys, want 6", len(kvs))
+		}
+		for _, kv := range kvs {
+			if kv.Lease != 0 && (kv.Lease != int64(lease.ID) || kv.TTL <= 0) {
+				t.Errorf("%s lease = %d ttl %d, want lease %d with ttl", kv.Key, kv.Lease, kv.TTL, lease.ID)
+			}
+		}
+
+		//导入到新前缀，重复的key以最后一个为准
+		dst := strings.TrimSuffix(pfx, "/") + fmt.Sprintf("-import%d/", format)
+		for i := range kvs {
+			key, val, _ := kvs[i].decode()
+			kvs[i] = newKV(dst+strings.TrimPrefix(key, pfx), []byte(val))
+		}
+		kvs = append(kvs, KV{Key: dst + "k0", Value: "last"})
+		var in bytes.Buffer
+		json.NewEncoder(&in).Encode(Header{})
+		for _, kv := range kvs {
+			json.NewEncoder(&in).Encode(kv)
+		}
+		stats, err := Import(ctx, cli, &in, ImportOptions{Format: NDJSON, BatchSize: 4})
+		if err != nil {
+			t.Fatalf("Import: %v", err)
+		}
+		if stats.Total != 7 || stats.Duplicates != 1 || stats.Created != 6 || stats.Batches != 2 {
+			t.Errorf("stats = %+v", stats)
+		}
+		resp, err := cli.Get(ctx, dst+"k0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Kvs[0].Value) != "last" {
+			t.Errorf("%sk0 = %s, want last", dst, resp.Kvs[0].Value)
+		}
+	}
+}
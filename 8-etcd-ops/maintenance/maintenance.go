@@ -0,0 +1,200 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+
+	"etcd-example/8-etcd-ops/member"
+)
+
+const (
+	defaultInterval        = time.Hour
+	defaultRetention       = 10000
+	defaultDefragThreshold = 100 * 1024 * 1024
+	defaultTimeout         = 5 * time.Second
+)
+
+//Option 维护任务的可选配置
+type Option func(*Scheduler)
+
+//WithInterval 设置执行间隔，默认1小时
+func WithInterval(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.interval = d
+	}
+}
+
+//WithRetention 设置压缩时保留的版本数，默认10000，为0时不压缩
+func WithRetention(revs int64) Option {
+	return func(s *Scheduler) {
+		s.retention = revs
+	}
+}
+
+//WithDefragThreshold 设置碎片整理的DB大小阈值(字节)，小于阈值的成员跳过，默认100MB，为负数时不整理
+func WithDefragThreshold(size int64) Option {
+	return func(s *Scheduler) {
+		s.defragThreshold = size
+	}
+}
+
+//WithTimeout 设置获取成员状态的超时，碎片整理不受此限制，默认5秒
+func WithTimeout(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.timeout = d
+	}
+}
+
+//DefragResult 一个成员的碎片整理结果
+type DefragResult struct {
+	Name     string        `json:"name"`
+	Endpoint string        `json:"endpoint"`
+	Leader   bool          `json:"leader"`
+	Before   int64         `json:"before"` //整理前的DB大小
+	After    int64         `json:"after"`  //整理后的DB大小
+	Duration time.Duration `json:"duration"`
+	Skipped  string        `json:"skipped,omitempty"` //跳过的原因
+}
+
+//Report 一次维护的结果
+type Report struct {
+	Revision    int64          `json:"revision"`     //执行时的版本号
+	CompactedTo int64          `json:"compacted_to"` //压缩到的版本号，没有压缩时为0
+	Defrags     []DefragResult `json:"defrags"`
+}
+
+//Scheduler 定期压缩和碎片整理。压缩保留最近retention个版本；
+//碎片整理逐个成员执行，leader最后整理，DB大小小于阈值的成员跳过
+type Scheduler struct {
+	cli             *clientv3.Client
+	interval        time.Duration
+	retention       int64
+	defragThreshold int64
+	timeout         time.Duration
+}
+
+//New 新建维护任务
+func New(cli *clientv3.Client, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		cli:             cli,
+		interval:        defaultInterval,
+		retention:       defaultRetention,
+		defragThreshold: defaultDefragThreshold,
+		timeout:         defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+//Run 立即执行一次，之后每interval执行一次，阻塞直到ctx取消。
+//单次失败只记录日志，下次继续执行
+func (s *Scheduler) Run(ctx context.Context, report func(*Report)) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		r, err := s.RunOnce(ctx)
+		if err != nil {
+			log.Printf("maintenance err: %v", err)
+		}
+		if r != nil && report != nil {
+			report(r)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//RunOnce 执行一次压缩和碎片整理，出错时返回已完成部分的结果
+func (s *Scheduler) RunOnce(ctx context.Context) (*Report, error) {
+	r := &Report{}
+	if err := s.compact(ctx, r); err != nil {
+		return r, err
+	}
+	if s.defragThreshold < 0 {
+		return r, nil
+	}
+	return r, s.defrag(ctx, r)
+}
+
+//compact 压缩到当前版本号减retention
+func (s *Scheduler) compact(ctx context.Context, r *Report) error {
+	resp, err := s.cli.Get(ctx, "compaction", clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	r.Revision = resp.Header.Revision
+	rev := r.Revision - s.retention
+	if s.retention <= 0 || rev <= 0 {
+		return nil
+	}
+	//WithCompactPhysical等待压缩完成后返回，之后整理碎片才能回收空间
+	_, err = s.cli.Compact(ctx, rev, clientv3.WithCompactPhysical())
+	if err == rpctypes.ErrCompacted {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.CompactedTo = rev
+	return nil
+}
+
+//defrag 逐个整理成员的碎片，leader最后整理。有不健康的成员时不整理，
+//整理期间成员不能处理请求，同时整理会降低可用性
+func (s *Scheduler) defrag(ctx context.Context, r *Report) error {
+	members, err := member.List(ctx, s.cli, s.timeout)
+	if err != nil {
+		return err
+	}
+	var leader *member.Member
+	for _, m := range members {
+		if !m.Healthy {
+			return fmt.Errorf("maintenance: member %s unhealthy, skip defragment: %s", m.Name, m.Error)
+		}
+		if m.Leader {
+			leader = m
+		}
+	}
+	ordered := make([]*member.Member, 0, len(members))
+	for _, m := range members {
+		if m != leader {
+			ordered = append(ordered, m)
+		}
+	}
+	if leader != nil {
+		ordered = append(ordered, leader)
+	}
+
+	for _, m := range ordered {
+		d := DefragResult{Name: m.Name, Endpoint: m.ClientURLs[0], Leader: m.Leader, Before: m.DBSize, After: m.DBSize}
+		if m.DBSize < s.defragThreshold {
+			d.Skipped = "below threshold"
+			r.Defrags = append(r.Defrags, d)
+			continue
+		}
+		start := time.Now()
+		if _, err := s.cli.Defragment(ctx, d.Endpoint); err != nil {
+			return err
+		}
+		d.Duration = time.Since(start)
+		sctx, cancel := context.WithTimeout(ctx, s.timeout)
+		status, err := s.cli.Status(sctx, d.Endpoint)
+		cancel()
+		if err != nil {
+			return err
+		}
+		d.After = status.DbSize
+		r.Defrags = append(r.Defrags, d)
+	}
+	return nil
+}
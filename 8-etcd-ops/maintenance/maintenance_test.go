@@ -0,0 +1,72 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+
+	"etcd-example/internal/etcdtest"
+)
+
+func TestRunOnce(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	//保留足够的版本号，不影响同时运行的其他测试
+	const retention = 100
+	for i := 0; i < retention+1; i++ {
+		if _, err := cli.Put(ctx, "/test/"+t.Name(), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := New(cli, WithRetention(retention), WithDefragThreshold(0)).RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if r.CompactedTo != r.Revision-retention {
+		t.Fatalf("CompactedTo = %d, want %d", r.CompactedTo, r.Revision-retention)
+	}
+	if _, err := cli.Get(ctx, "/test/"+t.Name(), clientv3.WithRev(r.CompactedTo-1)); err == nil {
+		t.Fatal("Get compacted revision succeeded")
+	}
+	if len(r.Defrags) == 0 {
+		t.Fatal("no member defragmented")
+	}
+	for i, d := range r.Defrags {
+		if d.Skipped != "" || d.After <= 0 {
+			t.Errorf("defrag %s = %+v", d.Name, d)
+		}
+		//leader最后整理
+		if d.Leader != (i == len(r.Defrags)-1) {
+			t.Errorf("defrag %d is %s, leader=%v", i, d.Name, d.Leader)
+		}
+	}
+	if _, err := cli.Delete(ctx, "/test/"+t.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunOnceSkipDefrag(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	//版本号不足retention时不压缩，DB小于阈值的成员跳过整理
+	r, err := New(cli, WithRetention(1<<40), WithDefragThreshold(1<<40)).RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if r.CompactedTo != 0 || r.Revision == 0 {
+		t.Fatalf("report = %+v, want revision without compaction", r)
+	}
+	for _, d := range r.Defrags {
+		if d.Skipped != "below threshold" || d.Before != d.After {
+			t.Errorf("defrag %s = %+v, want skipped", d.Name, d)
+		}
+	}
+	//阈值小于0时不整理
+	r, err = New(cli, WithRetention(1<<40), WithDefragThreshold(-1)).RunOnce(ctx)
+	if err != nil || len(r.Defrags) != 0 {
+		t.Fatalf("RunOnce = %+v, %v, want no defrag", r, err)
+	}
+}
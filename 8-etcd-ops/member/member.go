@@ -0,0 +1,258 @@
+package member
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+)
+
+var (
+	//ErrNotFound 没有该名称的成员
+	ErrNotFound = errors.New("member: not found")
+	//ErrUnstarted 集群中有已添加但未启动的成员
+	ErrUnstarted = errors.New("member: cluster has unstarted member")
+	//ErrQuorum 变更后健康成员数不足法定人数
+	ErrQuorum = errors.New("member: not enough healthy members for quorum")
+	//ErrFaultTolerance 变更会降低集群可容忍的故障成员数
+	ErrFaultTolerance = errors.New("member: change reduces fault tolerance")
+)
+
+//Member 成员及其健康状态
+type Member struct {
+	ID         string   `json:"id"` //十六进制，与etcdctl一致
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peer_urls"`
+	ClientURLs []string `json:"client_urls"`
+	Healthy    bool     `json:"healthy"`
+	Leader     bool     `json:"leader"`
+	Version    string   `json:"version,omitempty"`
+	DBSize     int64    `json:"db_size,omitempty"`
+	RaftIndex  uint64   `json:"raft_index,omitempty"`
+	RaftTerm   uint64   `json:"raft_term,omitempty"`
+	Error      string   `json:"error,omitempty"` //获取状态失败的原因
+
+	id uint64
+}
+
+//Started 成员是否已启动，已添加但未启动的成员没有名称和client地址
+func (m *Member) Started() bool {
+	return m.Name != ""
+}
+
+//List 返回所有成员，并行通过每个成员的第一个client地址获取状态，每个请求的超时为timeout
+func List(ctx context.Context, cli *clientv3.Client, timeout time.Duration) ([]*Member, error) {
+	resp, err := cli.MemberList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*Member, len(resp.Members))
+	var wg sync.WaitGroup
+	for i, m := range resp.Members {
+		members[i] = &Member{
+			ID:         fmt.Sprintf("%x", m.ID),
+			Name:       m.Name,
+			PeerURLs:   m.PeerURLs,
+			ClientURLs: m.ClientURLs,
+			id:         m.ID,
+		}
+		if len(m.ClientURLs) == 0 {
+			members[i].Error = "unstarted"
+			continue
+		}
+		wg.Add(1)
+		go func(m *Member) {
+			defer wg.Done()
+			sctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			status, err := cli.Status(sctx, m.ClientURLs[0])
+			if err != nil {
+				m.Error = err.Error()
+				return
+			}
+			m.Healthy = true
+			m.Leader = status.Leader == status.Header.MemberId
+			m.Version = status.Version
+			m.DBSize = status.DbSize
+			m.RaftIndex = status.RaftIndex
+			m.RaftTerm = status.RaftTerm
+		}(members[i])
+	}
+	wg.Wait()
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members, nil
+}
+
+//find 按名称或十六进制ID查找成员，未启动的成员没有名称只能按ID查找
+func find(members []*Member, name string) (*Member, error) {
+	for _, m := range members {
+		if m.Name == name || m.ID == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+//quorum n个成员的法定人数
+func quorum(n int) int {
+	return n/2 + 1
+}
+
+//tolerance 共n个成员、其中healthy个健康时还能容忍的故障成员数，小于0时已失去法定人数
+func tolerance(healthy, n int) int {
+	return healthy - quorum(n)
+}
+
+//healthy 返回健康成员数
+func healthy(members []*Member) int {
+	n := 0
+	for _, m := range members {
+		if m.Healthy {
+			n++
+		}
+	}
+	return n
+}
+
+//AddResult 添加成员的结果
+type AddResult struct {
+	Member         *Member `json:"member"`
+	InitialCluster string  `json:"initial_cluster"` //新成员启动时的--initial-cluster
+}
+
+//Flags 返回新成员启动时需要的参数
+func (r *AddResult) Flags() []string {
+	return []string{
+		"--name=" + r.Member.Name,
+		"--initial-advertise-peer-urls=" + strings.Join(r.Member.PeerURLs, ","),
+		"--initial-cluster=" + r.InitialCluster,
+		"--initial-cluster-state=existing",
+	}
+}
+
+//CheckAdd 检查是否可以安全地添加成员：没有未启动的成员，添加后健康成员数仍满足法定人数，且不降低容错能力。
+//v3.3没有learner，新成员添加后立即计入法定人数，在新成员启动前按故障成员计算。
+//成员数为奇数时添加会使法定人数加一，容错能力降低，返回ErrFaultTolerance，force为true时跳过这项检查
+func CheckAdd(members []*Member, force bool) error {
+	for _, m := range members {
+		if !m.Started() {
+			return fmt.Errorf("%w: %s", ErrUnstarted, m.ID)
+		}
+	}
+	n := len(members)
+	h := healthy(members)
+	if h < quorum(n+1) {
+		return fmt.Errorf("%w: %d healthy, need %d after add", ErrQuorum, h, quorum(n+1))
+	}
+	if before, after := tolerance(h, n), tolerance(h, n+1); after < before && !force {
+		return fmt.Errorf("%w: tolerates %d failed members before add, %d until the new member starts", ErrFaultTolerance, before, after)
+	}
+	return nil
+}
+
+//Add 检查通过后添加名为name的成员，force与CheckAdd相同
+func Add(ctx context.Context, cli *clientv3.Client, members []*Member, name string, peerURLs []string, force bool) (*AddResult, error) {
+	if _, err := find(members, name); err == nil || name == "" {
+		return nil, fmt.Errorf("member: empty or existing name %q", name)
+	}
+	if err := CheckAdd(members, force); err != nil {
+		return nil, err
+	}
+	resp, err := cli.MemberAdd(ctx, peerURLs)
+	if err != nil {
+		return nil, err
+	}
+	m := &Member{ID: fmt.Sprintf("%x", resp.Member.ID), Name: name, PeerURLs: resp.Member.PeerURLs, id: resp.Member.ID}
+	//MemberAdd返回添加后的成员列表，新成员没有名称
+	var cluster []string
+	for _, mm := range resp.Members {
+		n := mm.Name
+		if mm.ID == resp.Member.ID {
+			n = name
+		}
+		for _, u := range mm.PeerURLs {
+			cluster = append(cluster, n+"="+u)
+		}
+	}
+	return &AddResult{Member: m, InitialCluster: strings.Join(cluster, ",")}, nil
+}
+
+//CheckRemove 查找名称或ID为name的成员，检查移除后剩余的健康成员数是否满足法定人数。
+//移除leader时剩余的健康成员满足法定人数，保证有成员可以接任leader
+func CheckRemove(members []*Member, name string) (*Member, error) {
+	m, err := find(members, name)
+	if err != nil {
+		return nil, err
+	}
+	n := healthy(members)
+	if m.Healthy {
+		n--
+	}
+	if n < quorum(len(members)-1) {
+		return nil, fmt.Errorf("%w: %d healthy, need %d after remove", ErrQuorum, n, quorum(len(members)-1))
+	}
+	return m, nil
+}
+
+//Transferee 返回移除leader前接任leader的成员：raft index最大的健康成员，没有时返回nil
+func Transferee(members []*Member, leader *Member) *Member {
+	var to *Member
+	for _, m := range members {
+		if m == leader || !m.Healthy || !m.Started() {
+			continue
+		}
+		if to == nil || m.RaftIndex > to.RaftIndex {
+			to = m
+		}
+	}
+	return to
+}
+
+//Remove 检查通过后移除名称或ID为name的成员。移除leader时先把leadership转移给Transferee，
+//避免移除后集群在选出新leader前不可用
+func Remove(ctx context.Context, cli *clientv3.Client, members []*Member, name string) (*Member, error) {
+	m, err := CheckRemove(members, name)
+	if err != nil {
+		return nil, err
+	}
+	if m.Leader {
+		to := Transferee(members, m)
+		if err := moveLeader(ctx, cli, m, to); err != nil {
+			return m, fmt.Errorf("member: move leader to %s: %v", to.Name, err)
+		}
+		m.Leader, to.Leader = false, true
+	}
+	_, err = cli.MemberRemove(ctx, m.id)
+	return m, err
+}
+
+//moveLeader 把leadership从leader转移给to。MoveLeader只能由leader处理，所以直接连接leader的client地址
+func moveLeader(ctx context.Context, cli *clientv3.Client, leader, to *Member) error {
+	conn, err := cli.Dial(leader.ClientURLs[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	mc := clientv3.NewMaintenanceFromMaintenanceClient(pb.NewMaintenanceClient(conn), cli)
+	_, err = mc.MoveLeader(ctx, to.id)
+	return err
+}
+
+//Update 更新名称或ID为name的成员的peer地址
+func Update(ctx context.Context, cli *clientv3.Client, members []*Member, name string, peerURLs []string) (*Member, error) {
+	m, err := find(members, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cli.MemberUpdate(ctx, m.id, peerURLs); err != nil {
+		return nil, err
+	}
+	m.PeerURLs = peerURLs
+	return m, nil
+}
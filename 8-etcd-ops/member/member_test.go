@@ -0,0 +1,115 @@
+package member
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//cluster 返回n个已启动的成员，前healthy个健康，第一个为leader
+func cluster(n, healthy int) []*Member {
+	members := make([]*Member, n)
+	for i := range members {
+		members[i] = &Member{
+			ID:         string(rune('a' + i)),
+			Name:       "infra" + string(rune('1'+i)),
+			ClientURLs: []string{"http://127.0.0.1:2379"},
+			Healthy:    i < healthy,
+			RaftIndex:  uint64(100 - i),
+			id:         uint64(i + 1),
+		}
+	}
+	members[0].Leader = healthy > 0
+	return members
+}
+
+func TestCheckAdd(t *testing.T) {
+	unstarted := append(cluster(3, 3), &Member{ID: "d"})
+	tests := []struct {
+		name    string
+		members []*Member
+		force   bool
+		want    error
+	}{
+		{name: "偶数成员", members: cluster(4, 4)},
+		{name: "奇数成员降低容错能力", members: cluster(3, 3), want: ErrFaultTolerance},
+		{name: "奇数成员强制添加", members: cluster(3, 3), force: true},
+		{name: "单成员添加后不满足法定人数", members: cluster(1, 1), force: true, want: ErrQuorum},
+		{name: "添加后不满足法定人数", members: cluster(3, 2), force: true, want: ErrQuorum},
+		{name: "偶数成员有故障", members: cluster(4, 3)},
+		{name: "有未启动的成员", members: unstarted, want: ErrUnstarted},
+	}
+	for _, tt := range tests {
+		if err := CheckAdd(tt.members, tt.force); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%s: CheckAdd = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCheckRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []*Member
+		remove  string
+		want    error
+	}{
+		{name: "移除follower", members: cluster(3, 3), remove: "infra3"},
+		{name: "移除故障成员", members: cluster(3, 2), remove: "infra3"},
+		{name: "移除后不满足法定人数", members: cluster(3, 2), remove: "infra2", want: ErrQuorum},
+		{name: "移除leader", members: cluster(3, 3), remove: "infra1"},
+		{name: "移除leader后不满足法定人数", members: cluster(2, 1), remove: "infra1", want: ErrQuorum},
+		{name: "按ID移除", members: cluster(3, 3), remove: "b"},
+		{name: "不存在", members: cluster(3, 3), remove: "infra9", want: ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := CheckRemove(tt.members, tt.remove); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%s: CheckRemove = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTransferee(t *testing.T) {
+	members := cluster(5, 4)
+	//infra2的raft index最大，infra5不健康
+	members[1].RaftIndex, members[4].RaftIndex = 200, 300
+	if to := Transferee(members, members[0]); to != members[1] {
+		t.Errorf("Transferee = %v, want infra2", to)
+	}
+	if to := Transferee(cluster(3, 1), nil); to == nil || to.Name != "infra1" {
+		t.Errorf("Transferee = %v, want infra1", to)
+	}
+	members = cluster(3, 1)
+	if to := Transferee(members, members[0]); to != nil {
+		t.Errorf("Transferee = %v, want nil", to)
+	}
+}
+
+func TestList(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	members, err := List(context.Background(), cli, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaders := 0
+	for _, m := range members {
+		if !m.Started() {
+			continue
+		}
+		if !m.Healthy {
+			t.Errorf("member %s unhealthy: %s", m.Name, m.Error)
+		}
+		if m.Version == "" || m.RaftIndex == 0 {
+			t.Errorf("member %s missing status: %+v", m.Name, m)
+		}
+		if m.Leader {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("got %d leaders, want 1", leaders)
+	}
+}
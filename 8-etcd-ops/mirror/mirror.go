@@ -0,0 +1,196 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/mirror"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+//maxTxnOps 每个事务的最大操作数，等于etcd默认的--max-txn-ops
+const maxTxnOps = 128
+
+//Option 镜像的可选配置
+type Option func(*Mirror)
+
+//WithDestPrefix 把源前缀改写为目标前缀，默认与源前缀相同
+func WithDestPrefix(pfx string) Option {
+	return func(m *Mirror) {
+		m.destPrefix = pfx
+	}
+}
+
+//WithCheckpointKey 设置目标集群中保存已应用版本号的key，默认为/__mirror加上源前缀
+func WithCheckpointKey(key string) Option {
+	return func(m *Mirror) {
+		m.checkpointKey = key
+	}
+}
+
+//Mirror 把源集群的前缀复制到目标集群：先全量同步，再通过watch持续复制。
+//已应用的源版本号与数据在同一事务中写入目标集群，重启后从该版本号继续复制
+type Mirror struct {
+	src, dst      *clientv3.Client
+	prefix        string //源前缀
+	destPrefix    string //目标前缀
+	checkpointKey string //目标集群中保存已应用版本号的key
+}
+
+//New 新建镜像，把src中前缀为prefix的key复制到dst
+func New(src, dst *clientv3.Client, prefix string, opts ...Option) *Mirror {
+	m := &Mirror{
+		src:           src,
+		dst:           dst,
+		prefix:        prefix,
+		destPrefix:    prefix,
+		checkpointKey: "/__mirror" + prefix,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+//Run 开始复制，阻塞直到ctx取消或出错。
+//没有检查点或检查点版本已被源集群压缩时重新全量同步
+func (m *Mirror) Run(ctx context.Context) error {
+	rev, err := m.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		if rev == 0 {
+			if rev, err = m.syncBase(ctx); err != nil {
+				return err
+			}
+		}
+		err = m.syncUpdates(ctx, rev)
+		if err != rpctypes.ErrCompacted {
+			return err
+		}
+		log.Printf("mirror %s: revision %d compacted, resync", m.prefix, rev)
+		rev = 0
+	}
+}
+
+//checkpoint 读取目标集群中的检查点
+func (m *Mirror) checkpoint(ctx context.Context) (int64, error) {
+	resp, err := m.dst.Get(ctx, m.checkpointKey)
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, err
+	}
+	return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+}
+
+//syncBase 全量同步源前缀在当前版本的所有key，删除目标中源已不存在的key，返回同步的版本号
+func (m *Mirror) syncBase(ctx context.Context) (int64, error) {
+	resp, err := m.src.Get(ctx, m.prefix, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	rev := resp.Header.Revision
+	start := time.Now()
+
+	keys := make(map[string]bool)
+	var ops []clientv3.Op
+	respc, errc := mirror.NewSyncer(m.src, m.prefix, rev).SyncBase(ctx)
+	for r := range respc {
+		for _, kv := range r.Kvs {
+			key := m.rewrite(string(kv.Key))
+			keys[key] = true
+			ops = append(ops, clientv3.OpPut(key, string(kv.Value)))
+		}
+	}
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+
+	//目标中多余的key
+	dresp, err := m.dst.Get(ctx, m.destPrefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range dresp.Kvs {
+		if key := string(kv.Key); !keys[key] && key != m.checkpointKey {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+	}
+	if err := m.apply(ctx, ops, rev); err != nil {
+		return 0, err
+	}
+	log.Printf("mirror %s: synced %d keys at revision %d in %v", m.prefix, len(keys), rev, time.Since(start))
+	return rev, nil
+}
+
+//syncUpdates 从rev之后watch源前缀，按watch响应应用到目标
+func (m *Mirror) syncUpdates(ctx context.Context, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wresp := range mirror.NewSyncer(m.src, m.prefix, rev).SyncUpdates(wctx) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		events := lastEvents(wresp.Events)
+		ops := make([]clientv3.Op, 0, len(events))
+		for _, ev := range events {
+			key := m.rewrite(string(ev.Kv.Key))
+			switch ev.Type {
+			case mvccpb.PUT:
+				ops = append(ops, clientv3.OpPut(key, string(ev.Kv.Value)))
+			case mvccpb.DELETE:
+				ops = append(ops, clientv3.OpDelete(key))
+			}
+		}
+		if err := m.apply(ctx, ops, wresp.Header.Revision); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("mirror: watch closed")
+}
+
+//lastEvents 同一个watch响应可能包含同一key的多个版本，只保留每个key的最后一个事件，
+//一个事务中不能重复修改同一个key
+func lastEvents(events []*clientv3.Event) []*clientv3.Event {
+	last := make(map[string]int, len(events))
+	for i, ev := range events {
+		last[string(ev.Kv.Key)] = i
+	}
+	if len(last) == len(events) {
+		return events
+	}
+	out := make([]*clientv3.Event, 0, len(last))
+	for i, ev := range events {
+		if last[string(ev.Kv.Key)] == i {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+//apply 分批提交ops，最后一批同时写入检查点
+func (m *Mirror) apply(ctx context.Context, ops []clientv3.Op, rev int64) error {
+	checkpoint := clientv3.OpPut(m.checkpointKey, strconv.FormatInt(rev, 10))
+	for len(ops) >= maxTxnOps {
+		if _, err := m.dst.Txn(ctx).Then(ops[:maxTxnOps]...).Commit(); err != nil {
+			return err
+		}
+		ops = ops[maxTxnOps:]
+	}
+	_, err := m.dst.Txn(ctx).Then(append(ops, checkpoint)...).Commit()
+	return err
+}
+
+//rewrite 把源key的前缀改写为目标前缀
+func (m *Mirror) rewrite(key string) string {
+	return m.destPrefix + strings.TrimPrefix(key, m.prefix)
+}
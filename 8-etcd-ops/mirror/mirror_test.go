@@ -0,0 +1,195 @@
+package mirror
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+
+	"etcd-example/internal/etcdtest"
+)
+
+//waitCheckpoint 等待检查点追上rev，5秒内没有追上或复制出错时测试失败
+func waitCheckpoint(t *testing.T, m *Mirror, rev int64, errc <-chan error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cp, err := m.checkpoint(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp >= rev {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("checkpoint = %d, want %d", cp, rev)
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("mirror: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+//dest 返回前缀下所有key和value
+func dest(t *testing.T, cli *clientv3.Client, pfx string) map[string]string {
+	t.Helper()
+	resp, err := cli.Get(context.Background(), pfx, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs
+}
+
+func TestLastEvents(t *testing.T) {
+	event := func(typ mvccpb.Event_EventType, key, val string) *clientv3.Event {
+		return &clientv3.Event{Type: typ, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(val)}}
+	}
+	put, del := mvccpb.PUT, mvccpb.DELETE
+	tests := []struct {
+		name   string
+		events []*clientv3.Event
+		want   []*clientv3.Event
+	}{
+		{
+			name:   "没有重复",
+			events: []*clientv3.Event{event(put, "a", "1"), event(del, "b", "")},
+			want:   []*clientv3.Event{event(put, "a", "1"), event(del, "b", "")},
+		},
+		{
+			name:   "多次修改",
+			events: []*clientv3.Event{event(put, "a", "1"), event(put, "b", "1"), event(put, "a", "2")},
+			want:   []*clientv3.Event{event(put, "b", "1"), event(put, "a", "2")},
+		},
+		{
+			name:   "修改后删除",
+			events: []*clientv3.Event{event(put, "a", "1"), event(del, "a", "")},
+			want:   []*clientv3.Event{event(del, "a", "")},
+		},
+		{
+			name:   "删除后重建",
+			events: []*clientv3.Event{event(del, "a", ""), event(put, "a", "3")},
+			want:   []*clientv3.Event{event(put, "a", "3")},
+		},
+	}
+	for _, tt := range tests {
+		if got := lastEvents(tt.events); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lastEvents = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSyncUpdatesDuplicateKeys(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	m := New(cli, cli, pfx+"/src/", WithDestPrefix(pfx+"/dst/"), WithCheckpointKey(pfx+"/checkpoint"))
+
+	resp, err := cli.Put(ctx, pfx+"/src/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//同一个key的多个版本在同一个watch响应中返回
+	cli.Put(ctx, pfx+"/src/a", "2")
+	cli.Put(ctx, pfx+"/src/b", "1")
+	last, err := cli.Delete(ctx, pfx+"/src/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	errc := make(chan error, 1)
+	go func() { errc <- m.syncUpdates(wctx, resp.Header.Revision) }()
+	waitCheckpoint(t, m, last.Header.Revision, errc)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("syncUpdates = %v, want %v", err, context.Canceled)
+	}
+
+	dst, err := cli.Get(ctx, pfx+"/dst/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.Kvs) != 1 || string(dst.Kvs[0].Key) != pfx+"/dst/a" || string(dst.Kvs[0].Value) != "2" {
+		t.Errorf("dest = %v, want only %s/dst/a=2", dst.Kvs, pfx)
+	}
+}
+
+func TestSyncBase(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	m := New(cli, cli, pfx+"/src/", WithDestPrefix(pfx+"/dst/"), WithCheckpointKey(pfx+"/checkpoint"))
+
+	cli.Put(ctx, pfx+"/src/a", "1")
+	cli.Put(ctx, pfx+"/src/b/c", "2")
+	//目标中源已不存在的key被删除，过期的值被覆盖
+	cli.Put(ctx, pfx+"/dst/a", "0")
+	cli.Put(ctx, pfx+"/dst/stale", "0")
+	rev, err := m.syncBase(ctx)
+	if err != nil {
+		t.Fatalf("syncBase: %v", err)
+	}
+	want := map[string]string{pfx + "/dst/a": "1", pfx + "/dst/b/c": "2"}
+	if got := dest(t, cli, pfx+"/dst/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dest = %v, want %v", got, want)
+	}
+	if cp, err := m.checkpoint(ctx); err != nil || cp != rev {
+		t.Errorf("checkpoint = %d, %v, want %d", cp, err, rev)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	cli := etcdtest.NewClient(t)
+	defer cli.Close()
+	ctx := context.Background()
+	pfx := etcdtest.Prefix(t)
+	m := New(cli, cli, pfx+"/src/", WithDestPrefix(pfx+"/dst/"), WithCheckpointKey(pfx+"/checkpoint"))
+	run := func() (context.CancelFunc, <-chan error) {
+		rctx, cancel := context.WithCancel(ctx)
+		errc := make(chan error, 1)
+		go func() { errc <- m.Run(rctx) }()
+		return cancel, errc
+	}
+	stop := func(cancel context.CancelFunc, errc <-chan error) {
+		cancel()
+		if err := <-errc; err != context.Canceled {
+			t.Fatalf("Run = %v, want %v", err, context.Canceled)
+		}
+	}
+
+	resp, err := cli.Put(ctx, pfx+"/src/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel, errc := run()
+	waitCheckpoint(t, m, resp.Header.Revision, errc)
+	stop(cancel, errc)
+
+	//停止期间的变更在重启后从检查点继续复制
+	cli.Put(ctx, pfx+"/src/b", "2")
+	last, err := cli.Delete(ctx, pfx+"/src/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//全量同步会删除源中不存在的key，该key保留说明没有重新全量同步
+	cli.Put(ctx, pfx+"/dst/marker", "x")
+	cancel, errc = run()
+	waitCheckpoint(t, m, last.Header.Revision, errc)
+	stop(cancel, errc)
+
+	want := map[string]string{pfx + "/dst/b": "2", pfx + "/dst/marker": "x"}
+	if got := dest(t, cli, pfx+"/dst/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dest = %v, want %v", got, want)
+	}
+}
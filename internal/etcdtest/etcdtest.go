@@ -0,0 +1,38 @@
+//Package etcdtest 测试共用的etcd连接和key前缀
+package etcdtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+//NewClient 连接etcd，默认为localhost:2379，可通过ETCD_ENDPOINTS指定，etcd不可用时跳过测试
+func NewClient(t *testing.T) *clientv3.Client {
+	t.Helper()
+	endpoints := []string{"localhost:2379"}
+	if eps := os.Getenv("ETCD_ENDPOINTS"); eps != "" {
+		endpoints = strings.Split(eps, ",")
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: time.Second})
+	if err != nil {
+		t.Skipf("etcd unavailable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cli.Get(ctx, "health"); err != nil {
+		cli.Close()
+		t.Skipf("etcd unavailable: %v", err)
+	}
+	return cli
+}
+
+//Prefix 返回测试独占的key前缀，不以/结尾
+func Prefix(t *testing.T) string {
+	return fmt.Sprintf("/test/%s/%d", t.Name(), time.Now().UnixNano())
+}